@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Aggregation operators.
+const (
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateCount = "count"
+)
+
+// Aggregation exports an aggregate of the series of a metric, e.g. the sum
+// per application of a metric reported per process:
+//
+//	metric: rss_bytes
+//	name: application_rss_bytes
+//	op: sum
+//	by: [application_name]
+type Aggregation struct {
+	// Metric is the metric name or, for lines without a name, the mon_type
+	// aggregated.
+	Metric string `yaml:"metric"`
+
+	// Name is the name the aggregate is exported as.
+	Name string `yaml:"name"`
+
+	// Op is sum, avg, min, max or count.
+	Op string `yaml:"op"`
+
+	// By lists the labels the aggregate is grouped by. The aggregate has no
+	// other labels.
+	By []string `yaml:"by"`
+
+	// Replace drops the aggregated series, so that only the aggregate is
+	// exported.
+	Replace bool `yaml:"replace"`
+}
+
+// check checks the aggregation.
+func (a Aggregation) check() error {
+	if a.Metric == "" || !metricNameRE.MatchString(a.Name) {
+		return fmt.Errorf("aggregation: metric and a valid name are required")
+	}
+	switch a.Op {
+	case AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount:
+	default:
+		return fmt.Errorf("aggregation %s: op must be one of %q, %q, %q, %q or %q", a.Name,
+			AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount)
+	}
+	for _, label := range a.By {
+		if err := CheckLabelName(label); err != nil {
+			return fmt.Errorf("aggregation %s: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// aggregate is the state of one group of an aggregation.
+type aggregate struct {
+	labels map[string]string
+	value  float64
+	count  int
+}
+
+// Aggregate appends the aggregates of metrics and removes the series of
+// aggregations with Replace.
+func Aggregate(metrics []Metric, aggregations []Aggregation) []Metric {
+	if len(aggregations) == 0 {
+		return metrics
+	}
+
+	var results []Metric
+	replaced := make([]bool, len(metrics))
+	for _, a := range aggregations {
+		var order []string
+		groups := make(map[string]*aggregate)
+		for i, metric := range metrics {
+			key := metric.Name
+			if key == "" {
+				key = metric.MonType
+			}
+			if key != a.Metric {
+				continue
+			}
+			replaced[i] = replaced[i] || a.Replace
+
+			all := metric.AllLabels()
+			labels := make(map[string]string, len(a.By))
+			for _, label := range a.By {
+				labels[label] = all[label]
+			}
+			group := seriesKey("", labels)
+			g, ok := groups[group]
+			if !ok {
+				g = &aggregate{labels: labels, value: metric.Value}
+				groups[group] = g
+				order = append(order, group)
+			} else {
+				switch a.Op {
+				case AggregateSum, AggregateAvg:
+					g.value += metric.Value
+				case AggregateMin:
+					g.value = math.Min(g.value, metric.Value)
+				case AggregateMax:
+					g.value = math.Max(g.value, metric.Value)
+				}
+			}
+			g.count++
+		}
+
+		for _, group := range order {
+			g := groups[group]
+			switch a.Op {
+			case AggregateAvg:
+				g.value /= float64(g.count)
+			case AggregateCount:
+				g.value = float64(g.count)
+			}
+			results = append(results, Metric{Name: a.Name, Labels: g.labels, Value: g.value})
+		}
+	}
+
+	kept := metrics[:0]
+	for i, metric := range metrics {
+		if !replaced[i] {
+			kept = append(kept, metric)
+		}
+	}
+	return append(kept, results...)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		aggregation Aggregation
+		want        []string
+	}{
+		{"sum", Aggregation{Metric: "rss_bytes", Name: "app_rss_bytes", Op: AggregateSum, By: []string{"app"}},
+			[]string{`app_rss_bytes{app="db"} 5`, `app_rss_bytes{app="web"} 30`}},
+		{"avg", Aggregation{Metric: "rss_bytes", Name: "app_rss_bytes", Op: AggregateAvg, By: []string{"app"}},
+			[]string{`app_rss_bytes{app="db"} 5`, `app_rss_bytes{app="web"} 15`}},
+		{"min", Aggregation{Metric: "rss_bytes", Name: "app_rss_bytes", Op: AggregateMin, By: []string{"app"}},
+			[]string{`app_rss_bytes{app="db"} 5`, `app_rss_bytes{app="web"} 10`}},
+		{"max", Aggregation{Metric: "rss_bytes", Name: "app_rss_bytes", Op: AggregateMax, By: []string{"app"}},
+			[]string{`app_rss_bytes{app="db"} 5`, `app_rss_bytes{app="web"} 20`}},
+		{"count without grouping", Aggregation{Metric: "rss_bytes", Name: "processes", Op: AggregateCount},
+			[]string{`processes{} 3`}},
+		{"mon_type", Aggregation{Metric: "cpu", Name: "cpu_total", Op: AggregateSum},
+			[]string{`cpu_total{} 0.5`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.aggregation.check(); err != nil {
+				t.Fatal(err)
+			}
+			metrics := []Metric{
+				{Name: "rss_bytes", Labels: map[string]string{"app": "web", "process": "a"}, Value: 10},
+				{Name: "rss_bytes", Labels: map[string]string{"app": "web", "process": "b"}, Value: 20},
+				{Name: "rss_bytes", Labels: map[string]string{"app": "db", "process": "c"}, Value: 5},
+				{MonType: "cpu", Component: "web", Value: 0.5},
+			}
+			metrics = Aggregate(metrics, []Aggregation{tc.aggregation})
+			if len(metrics) != 4+len(tc.want) {
+				t.Fatalf("got %d metrics, want the 4 aggregated and %d aggregates", len(metrics), len(tc.want))
+			}
+			var got []string
+			for _, metric := range metrics[4:] {
+				got = append(got, formatMetric(metric))
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	replace := Aggregation{Metric: "rss_bytes", Name: "rss_bytes_total", Op: AggregateSum, Replace: true}
+	metrics := Aggregate([]Metric{
+		{Name: "rss_bytes", Labels: map[string]string{"process": "a"}, Value: 1},
+		{Name: "rss_bytes", Labels: map[string]string{"process": "b"}, Value: 2},
+		{Name: "up", Value: 1},
+	}, []Aggregation{replace})
+	if len(metrics) != 2 || metrics[0].Name != "up" || metrics[1].Name != "rss_bytes_total" || metrics[1].Value != 3 {
+		t.Errorf("replace: got %+v", metrics)
+	}
+
+	for _, a := range []Aggregation{
+		{Name: "x", Op: AggregateSum},
+		{Metric: "x", Name: "0x", Op: AggregateSum},
+		{Metric: "x", Name: "y", Op: "median"},
+		{Metric: "x", Name: "y", Op: AggregateSum, By: []string{"__name__"}},
+	} {
+		if err := a.check(); err == nil {
+			t.Errorf("%+v: expected an error", a)
+		}
+	}
+}
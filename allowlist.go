@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// ParseNetworks parses CIDR networks and single IP addresses.
+func ParseNetworks(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid network %q: use a CIDR such as 10.0.0.0/8 or an IP address", network)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// AllowNetworks rejects the requests to handler from outside of networks,
+// unless there are none. Requests over a Unix domain socket carry no
+// address and are governed by the permissions of the socket instead.
+func AllowNetworks(handler http.Handler, networks []netip.Prefix) http.Handler {
+	if len(networks) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			handler.ServeHTTP(w, r) // Unix domain socket
+			return
+		}
+		if addr, err := netip.ParseAddr(host); err == nil {
+			addr = addr.Unmap()
+			for _, network := range networks {
+				if network.Contains(addr) {
+					handler.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		log.Printf("Rejected request to %s from %s outside the allowed networks", r.URL.Path, r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ScriptStatus describes a script in the responses of the admin API and on
+// the status page. The last run is omitted until the script ran once.
+type ScriptStatus struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Schedule    string `json:"schedule"`
+	Disabled    bool   `json:"disabled"`
+	Quarantined bool   `json:"quarantined"`
+	Collected   bool   `json:"collected"`
+	// Series is the number of series exported by the last successful run.
+	Series int `json:"series"`
+
+	LastRun         *time.Time `json:"last_run,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds,omitempty"`
+	ExitCode        int        `json:"exit_code,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// Status returns the current status of the script.
+func (s *Script) Status() ScriptStatus {
+	status := ScriptStatus{
+		Name:        s.Config.Name,
+		Path:        s.Config.Path,
+		Schedule:    DescribeSchedule(s.Config),
+		Disabled:    s.disabled.Load(),
+		Quarantined: s.quarantined.Load(),
+		Collected:   s.collected.Load(),
+	}
+
+	s.mu.RLock()
+	series, last := s.series, s.last
+	s.mu.RUnlock()
+	status.Series = series
+	if !last.Start.IsZero() {
+		status.LastRun = &last.Start
+		status.DurationSeconds = last.Duration.Seconds()
+		status.ExitCode = last.ExitCode
+		if last.Err != nil {
+			status.Error = last.Err.Error()
+		}
+	}
+	return status
+}
+
+// HandleAPI registers the admin API on mux, wrapping its handlers with
+// protect. As it runs the on_scrape scripts like a scrape, metrics.json is
+// limited by limiter as well:
+//
+//	GET  /api/v1/scripts                 lists the scripts
+//	POST /api/v1/scripts/{name}/run      runs a script right away
+//	POST /api/v1/scripts/{name}/disable  stops running a script
+//	POST /api/v1/scripts/{name}/enable   enables a disabled or quarantined script
+//	GET  /api/v1/metrics.json            serves the exported metrics as JSON
+func (e *Exporter) HandleAPI(mux *http.ServeMux, protect func(http.Handler) http.Handler, limiter *ScrapeLimiter) {
+	mux.Handle("GET /api/v1/scripts", protect(http.HandlerFunc(e.scriptsHandler)))
+	mux.Handle("GET /api/v1/metrics.json", protect(limiter.Handler(http.HandlerFunc(e.metricsHandler))))
+	mux.Handle("POST /api/v1/scripts/{name}/{action}", protect(http.HandlerFunc(e.scriptActionHandler)))
+}
+
+// Statuses returns the status of all scripts, ordered by name.
+func (e *Exporter) Statuses() []ScriptStatus {
+	e.mu.RLock()
+	statuses := make([]ScriptStatus, 0, len(e.scripts))
+	for _, script := range e.scripts {
+		statuses = append(statuses, script.Status())
+	}
+	e.mu.RUnlock()
+	slices.SortFunc(statuses, func(a, b ScriptStatus) int { return strings.Compare(a.Name, b.Name) })
+	return statuses
+}
+
+// scriptsHandler serves the status of all scripts.
+func (e *Exporter) scriptsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, e.Statuses())
+}
+
+// scriptActionHandler applies the action of the request to a script and
+// serves the resulting status of the script.
+func (e *Exporter) scriptActionHandler(w http.ResponseWriter, r *http.Request) {
+	name, action := r.PathValue("name"), r.PathValue("action")
+	e.mu.RLock()
+	script, ok := e.scripts[name]
+	e.mu.RUnlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown script %q", name))
+		return
+	}
+
+	switch action {
+	case "run":
+		if err := script.Trigger(); err != nil {
+			writeJSONError(w, http.StatusConflict, fmt.Errorf("cannot run script %q: %w", name, err))
+			return
+		}
+	case "disable":
+		if !script.Disable() {
+			writeJSONError(w, http.StatusConflict, fmt.Errorf("script %q is already disabled", name))
+			return
+		}
+	case "enable":
+		if !script.Enable() {
+			writeJSONError(w, http.StatusConflict, fmt.Errorf("script %q is neither quarantined nor disabled", name))
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	log.Printf("Applied %s to script %s via HTTP.", action, name)
+	writeJSON(w, http.StatusOK, script.Status())
+}
+
+// ScriptSnapshot is a script with the metrics it currently exports.
+type ScriptSnapshot struct {
+	ScriptStatus
+	Metrics []SnapshotMetric `json:"metrics"`
+}
+
+// SnapshotMetric is an exported sample. Values are formatted as strings,
+// as JSON has no NaN or infinities. Histograms and summaries carry their
+// count, sum and buckets or quantiles instead of a value.
+type SnapshotMetric struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Labels    map[string]string `json:"labels"`
+	Value     string            `json:"value,omitempty"`
+	Count     *uint64           `json:"count,omitempty"`
+	Sum       string            `json:"sum,omitempty"`
+	Buckets   map[string]uint64 `json:"buckets,omitempty"`
+	Quantiles map[string]string `json:"quantiles,omitempty"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+}
+
+// Snapshot returns the status and current metrics of the script.
+func (s *Script) Snapshot() (ScriptSnapshot, error) {
+	snapshot := ScriptSnapshot{Metrics: []SnapshotMetric{}}
+	families, err := s.Gather()
+	snapshot.ScriptStatus = s.Status()
+	for _, family := range families {
+		for _, m := range family.Metric {
+			snapshot.Metrics = append(snapshot.Metrics, newSnapshotMetric(family, m))
+		}
+	}
+	return snapshot, err
+}
+
+// newSnapshotMetric converts a sample of family.
+func newSnapshotMetric(family *dto.MetricFamily, m *dto.Metric) SnapshotMetric {
+	sample := SnapshotMetric{
+		Name:   family.GetName(),
+		Type:   strings.ToLower(family.GetType().String()),
+		Labels: make(map[string]string, len(m.Label)),
+	}
+	for _, pair := range m.Label {
+		sample.Labels[pair.GetName()] = pair.GetValue()
+	}
+	if m.TimestampMs != nil {
+		timestamp := time.UnixMilli(m.GetTimestampMs())
+		sample.Timestamp = &timestamp
+	}
+	switch {
+	case m.Gauge != nil:
+		sample.Value = formatFloat(m.Gauge.GetValue())
+	case m.Counter != nil:
+		sample.Value = formatFloat(m.Counter.GetValue())
+	case m.Untyped != nil:
+		sample.Value = formatFloat(m.Untyped.GetValue())
+	case m.Histogram != nil:
+		count := m.Histogram.GetSampleCount()
+		sample.Count, sample.Sum = &count, formatFloat(m.Histogram.GetSampleSum())
+		sample.Buckets = make(map[string]uint64, len(m.Histogram.Bucket))
+		for _, bucket := range m.Histogram.Bucket {
+			sample.Buckets[formatFloat(bucket.GetUpperBound())] = bucket.GetCumulativeCount()
+		}
+	case m.Summary != nil:
+		count := m.Summary.GetSampleCount()
+		sample.Count, sample.Sum = &count, formatFloat(m.Summary.GetSampleSum())
+		sample.Quantiles = make(map[string]string, len(m.Summary.Quantile))
+		for _, quantile := range m.Summary.Quantile {
+			sample.Quantiles[formatFloat(quantile.GetQuantile())] = formatFloat(quantile.GetValue())
+		}
+	}
+	return sample
+}
+
+// formatFloat formats v like the Prometheus text format.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsHandler serves the status and current metrics of all scripts
+// but probes, ordered by name.
+func (e *Exporter) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	scripts := make([]*Script, 0, len(e.scripts))
+	for _, script := range e.scripts {
+		if !script.Config.Probe {
+			scripts = append(scripts, script)
+		}
+	}
+	e.mu.RUnlock()
+	slices.SortFunc(scripts, func(a, b *Script) int { return strings.Compare(a.Config.Name, b.Config.Name) })
+
+	snapshots := make([]ScriptSnapshot, len(scripts))
+	for i, script := range scripts {
+		var err error
+		if snapshots[i], err = script.Snapshot(); err != nil {
+			log.Printf("Error gathering metrics of %s: %v", script.Config.Name, err)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"scripts": snapshots})
+}
+
+// writeJSON serves v as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// writeJSONError serves err as a JSON object with an "error" field.
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against for unknown users, so that they take as
+// long to reject as wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// RequireAuth requires the credentials of one of the BasicAuthUsers or
+// the bearer token of the applied configuration for handler, unless there
+// are none. Verified passwords are cached since bcrypt is slow by design.
+func (e *Exporter) RequireAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mu.RLock()
+		users, token, tokenFile := e.users, e.token, e.tokenFile
+		e.mu.RUnlock()
+		if len(users) == 0 && token == "" && tokenFile == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if tokenFile != "" {
+				data, err := os.ReadFile(tokenFile)
+				if err != nil {
+					log.Printf("Error reading bearer token: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				token = strings.TrimSpace(string(data))
+			}
+			if token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		} else if user, password, ok := r.BasicAuth(); ok && len(users) > 0 && e.checkPassword(users, user, password) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if len(users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="custom_exporter"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// checkPassword reports whether password matches the hash of user.
+func (e *Exporter) checkPassword(users map[string]string, user, password string) bool {
+	hash, known := users[user]
+	key := sha256.Sum256([]byte(hash + "\x00" + user + "\x00" + password))
+
+	e.authMu.Lock()
+	cached := e.authCache[key]
+	e.authMu.Unlock()
+	if known && cached {
+		return true
+	}
+
+	if !known {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	e.authMu.Lock()
+	e.authCache[key] = true
+	e.authMu.Unlock()
+	return true
+}
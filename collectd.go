@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// FormatCollectd selects the PUTVAL protocol of the collectd exec plugin.
+const FormatCollectd = "collectd"
+
+// collectdDataSources names the values of common multi-value collectd
+// types. Values of other types are numbered instead.
+var collectdDataSources = map[string][]string{
+	"load":           {"shortterm", "midterm", "longterm"},
+	"if_octets":      {"rx", "tx"},
+	"if_packets":     {"rx", "tx"},
+	"if_errors":      {"rx", "tx"},
+	"if_dropped":     {"rx", "tx"},
+	"disk_octets":    {"read", "write"},
+	"disk_ops":       {"read", "write"},
+	"disk_time":      {"read", "write"},
+	"disk_merged":    {"read", "write"},
+	"io_octets":      {"rx", "tx"},
+	"io_packets":     {"rx", "tx"},
+	"ps_disk_octets": {"read", "write"},
+	"ps_disk_ops":    {"read", "write"},
+}
+
+// CollectdParser parses the output of collectd exec plugin scripts, e.g.
+//
+//	PUTVAL "web1/cpu-0/percent-idle" interval=10 N:97.5
+//
+// Values are exported as collectd_<plugin>_<type>[_<data source>] with the
+// host as instance label, the plugin instance as a label named after the
+// plugin and the type instance as type label. Other commands such as
+// PUTNOTIF are ignored.
+type CollectdParser struct {
+	Values ValuePolicy
+}
+
+// Parse implements Parser.
+func (p CollectdParser) Parse(r io.Reader) ([]Metric, error) {
+	return scanLines(r, p.ParseLine)
+}
+
+// ParseLine parses a single line of the exec plugin protocol.
+func (p CollectdParser) ParseLine(line string) ([]Metric, error) {
+	fields := splitCollectd(strings.TrimSpace(line))
+	if len(fields) == 0 || fields[0] != "PUTVAL" {
+		return nil, nil
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("PUTVAL needs an identifier and values")
+	}
+
+	identifier := strings.Split(fields[1], "/")
+	if len(identifier) != 3 {
+		return nil, fmt.Errorf("invalid identifier %q: want host/plugin[-instance]/type[-instance]", fields[1])
+	}
+	plugin, pluginInstance, _ := strings.Cut(identifier[1], "-")
+	typ, typeInstance, _ := strings.Cut(identifier[2], "-")
+
+	labels := map[string]string{"instance": identifier[0]}
+	if pluginInstance != "" {
+		labels[sanitizeName(plugin)] = pluginInstance
+	}
+	if typeInstance != "" {
+		labels["type"] = typeInstance
+	}
+	for name := range labels {
+		if err := CheckLabelName(name); err != nil {
+			return nil, err
+		}
+	}
+	name := "collectd_" + sanitizeName(plugin) + "_" + sanitizeName(typ)
+
+	// The last field holds the values; options such as interval=10 precede
+	// it.
+	values := strings.Split(fields[len(fields)-1], ":")
+	if len(values) < 2 {
+		return nil, fmt.Errorf("invalid values %q: want time:value[:value...]", fields[len(fields)-1])
+	}
+	var timestamp time.Time
+	if values[0] != "N" {
+		var err error
+		if timestamp, err = ParseTimestamp(values[0]); err != nil {
+			return nil, err
+		}
+	}
+	values = values[1:]
+
+	var metrics []Metric
+	for i, raw := range values {
+		metric := Metric{Name: name, Labels: labels, Timestamp: timestamp}
+		if len(values) > 1 {
+			if sources := collectdDataSources[typ]; len(sources) == len(values) {
+				metric.Name += "_" + sources[i]
+			} else {
+				metric.Name += fmt.Sprintf("_%d", i)
+			}
+		}
+		if raw == "U" {
+			raw = "" // Unknown values are handled by on_invalid_value.
+		}
+		value, ok, err := p.Values.Parse(metric.Name, raw)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			metric.Value = value
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// splitCollectd splits a line at spaces outside double quotes and removes
+// the quotes.
+func splitCollectd(line string) []string {
+	var fields []string
+	var field strings.Builder
+	quoted, inField := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted, inField = !quoted, true
+		case r == ' ' && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// runCollectd runs a collectd exec plugin script. As such scripts usually
+// keep running and print values periodically, every value is exported as
+// soon as it is read.
+func (s *Script) runCollectd(ctx context.Context) error {
+	parser, err := NewParser(s.Config)
+	if err != nil {
+		return err
+	}
+	collectd := parser.(CollectdParser)
+
+	latest := make(map[string]Metric)
+	return executeCommand(ctx, s.Config, func(stdout io.Reader) error {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, math.MaxInt) // Line length is limited by ExecuteCommand.
+
+		for n := 1; scanner.Scan(); n++ {
+			metrics, err := collectd.ParseLine(scanner.Text())
+			if err != nil {
+				parseErrorsTotal.WithLabelValues(s.Config.Name).Inc()
+				if s.Config.OnParseError != ParseErrorSkip {
+					return &LineError{Line: n, Err: err}
+				}
+				log.Printf("Skipped malformed output of %s: line %d: %v", s.Config.Name, n, err)
+				continue
+			}
+			if len(metrics) == 0 {
+				continue
+			}
+
+			for _, metric := range metrics {
+				latest[seriesKey(metric.FullName(), metric.AllLabels())] = metric
+			}
+			all := make([]Metric, 0, len(latest))
+			for _, metric := range latest {
+				all = append(all, metric)
+			}
+			if err := s.SetMetrics(all); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading command output: %w", err)
+		}
+		return nil
+	})
+}
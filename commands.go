@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// Version is the exporter version, set at build time with
+// -ldflags "-X main.Version=<version>".
+var Version = "dev"
+
+// Command is a subcommand of the exporter binary.
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) error
+}
+
+// Commands lists the available subcommands. It is filled in by init since
+// the usage output of every subcommand refers back to it.
+var Commands []*Command
+
+func init() {
+	Commands = []*Command{
+		{Name: "run", Summary: "Execute scripts periodically and serve metrics (default)", Run: RunCommand},
+		{Name: "validate", Summary: "Check the configuration and scripts without serving", Run: ValidateCommand},
+		{Name: "once", Summary: "Execute every script once and print the metrics", Run: OnceCommand},
+		{Name: "version", Summary: "Print the exporter version", Run: VersionCommand},
+	}
+}
+
+// FindCommand returns the subcommand with the given name, or nil.
+func FindCommand(name string) *Command {
+	for _, cmd := range Commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// ShutdownTimeout is how long requests in flight may take to finish when
+// the exporter is stopped through /-/quit.
+const ShutdownTimeout = 10 * time.Second
+
+// RunCommand sets up the HTTP server and starts metrics collection.
+func RunCommand(args []string) error {
+	opts := GetArgs("run", args)
+	addresses, err := opts.ListenAddresses()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := opts.TLSConfig()
+	if err != nil {
+		return err
+	}
+	if opts.MaxConnections < 0 {
+		return fmt.Errorf("-web.max-connections must not be negative")
+	}
+
+	if opts.StartupCheck != "" && opts.StartupCheck != StartupCheckNone {
+		cfg, err := opts.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if err := StartupCheck(cfg, opts.StartupCheck); err != nil {
+			return fmt.Errorf("startup check failed: %w", err)
+		}
+	}
+
+	if opts.MaxMemory > 0 {
+		debug.SetMemoryLimit(int64(opts.MaxMemory))
+	}
+
+	exporter := NewExporter(opts)
+	if opts.Graphite != "" {
+		exporter.Graphite = NewGraphite()
+	}
+	if err := exporter.Reload(); err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading configuration...")
+			if err := exporter.Reload(); err != nil {
+				log.Printf("Error reloading configuration: %v", err)
+			}
+		}
+	}()
+
+	if opts.ConfigFile != "" {
+		source, err := opts.ConfigSource()
+		if err != nil {
+			return err
+		}
+		go WatchConfig(context.Background(), source, exporter)
+	}
+
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, exporter}
+	if opts.StatsD != "" {
+		statsd := NewStatsD()
+		gatherers = append(gatherers, exporter.WithLabels(statsd.Registry))
+		go func() {
+			log.Printf("Receiving StatsD metrics on %s...", opts.StatsD)
+			log.Fatal(statsd.ListenAndServe(opts.StatsD))
+		}()
+	}
+	if exporter.Graphite != nil {
+		gatherers = append(gatherers, exporter.WithLabels(exporter.Graphite.Registry))
+		go func() {
+			log.Printf("Receiving Graphite metrics on %s...", opts.Graphite)
+			log.Fatal(exporter.Graphite.ListenAndServe(opts.Graphite))
+		}()
+	}
+	handler, err := NewHandler(opts, exporter, gatherers, tlsConfig != nil)
+	if err != nil {
+		return err
+	}
+
+	listeners := make([]net.Listener, len(addresses))
+	for i, address := range addresses {
+		if listeners[i], err = Listen(address); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+	}
+	if opts.SystemdSocket {
+		if listeners, err = SystemdListeners(); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+		for _, listener := range listeners {
+			addresses = append(addresses, "systemd socket "+listener.Addr().String())
+		}
+	}
+	if opts.MaxConnections > 0 {
+		limiter := NewConnLimiter(opts.MaxConnections)
+		for i := range listeners {
+			listeners[i] = limiter.Listener(listeners[i])
+		}
+	}
+	server := opts.HTTPServer(handler, tlsConfig)
+	errs := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		log.Printf("Starting server on %s...", addresses[i])
+		go func() {
+			switch {
+			case opts.WebConfigFile != "":
+				errs <- web.Serve(listener, server, &web.FlagConfig{WebConfigFile: &opts.WebConfigFile}, slog.Default())
+			case tlsConfig != nil:
+				errs <- server.ServeTLS(listener, "", "")
+			default:
+				errs <- server.Serve(listener)
+			}
+		}()
+	}
+	select {
+	case err := <-errs:
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-exporter.Quit():
+	}
+
+	// Let the response to /-/quit and other requests in flight finish.
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	exporter.Stop()
+	log.Println("Exporter stopped.")
+	return nil
+}
+
+// NewHandler returns the handler of the HTTP server, serving the metrics of
+// gatherers and the endpoints of exporter as set up by opts. tls tells
+// whether the server serves HTTPS.
+func NewHandler(opts *Options, exporter *Exporter, gatherers prometheus.Gatherer, tls bool) (http.Handler, error) {
+	networks, err := ParseNetworks(opts.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	protect := func(handler http.Handler) http.Handler {
+		return AllowNetworks(exporter.RequireAuth(handler), networks)
+	}
+
+	mux := http.NewServeMux()
+	if !strings.HasPrefix(opts.MetricsPath, "/") || opts.MetricsPath == "/" {
+		return nil, fmt.Errorf("-web.telemetry-path must be a path such as /metrics")
+	}
+	if opts.MaxRequests < 0 || opts.ClientRate < 0 {
+		return nil, fmt.Errorf("-web.max-requests and -web.client-rate-limit must not be negative")
+	}
+	limiter := NewScrapeLimiter(opts.MaxRequests, opts.ClientRate, opts.ClientBurst)
+	handlerOpts := promhttp.HandlerOpts{
+		EnableOpenMetrics:  opts.OpenMetrics,
+		DisableCompression: opts.DisableCompression,
+	}
+	mux.Handle(opts.MetricsPath, protect(limiter.Handler(promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(gatherers, handlerOpts),
+	))))
+	mux.Handle(strings.TrimSuffix(opts.MetricsPath, "/")+"/{script}",
+		protect(limiter.Handler(exporter.ScriptMetricsHandler(handlerOpts))))
+	mux.Handle("/probe", protect(limiter.Handler(exporter.ProbeHandler(handlerOpts))))
+	if opts.EnableAdminAPI {
+		mux.Handle("/-/reload", protect(http.HandlerFunc(exporter.ReloadHandler)))
+		mux.Handle("/-/enable", protect(http.HandlerFunc(exporter.EnableHandler)))
+		exporter.HandleAPI(mux, protect, limiter)
+	}
+	mux.Handle("/status", protect(http.HandlerFunc(exporter.StatusPage)))
+	if opts.EnableLifecycle {
+		mux.Handle("/-/quit", protect(http.HandlerFunc(exporter.QuitHandler)))
+	}
+	switch opts.ReadyAfter {
+	case ReadyAfterConfig, ReadyAfterAny, ReadyAfterAll:
+	default:
+		return nil, fmt.Errorf("-web.ready-after must be %q, %q or %q", ReadyAfterConfig, ReadyAfterAny, ReadyAfterAll)
+	}
+	mux.HandleFunc("/healthz", HealthHandler)
+	mux.HandleFunc("/readyz", exporter.ReadyHandler(opts.ReadyAfter))
+	mux.Handle("/", protect(exporter.LandingPage(opts.MetricsPath)))
+	if opts.DebugPprof || opts.DebugScripts {
+		debugAuth, err := DebugAuth(opts.DebugAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		auth := func(handler http.Handler) http.Handler {
+			return AllowNetworks(debugAuth(handler), networks)
+		}
+		if opts.DebugPprof {
+			HandleDebug(mux, auth)
+			log.Println("Serving profiles on /debug/pprof/")
+		}
+		if opts.DebugScripts {
+			exporter.HandleDebugScripts(mux, auth)
+			log.Println("Serving the last output of scripts on /debug/scripts/")
+		}
+	}
+
+	var handler http.Handler = mux
+	if opts.SecurityHeaders || len(opts.Headers) > 0 || len(opts.CORSOrigins) > 0 {
+		handler = WithHeaders(handler, opts.SecurityHeaders, tls, opts.Headers, opts.CORSOrigins)
+	}
+	if opts.AccessLog {
+		handler = AccessLog(handler)
+	}
+	return handler, nil
+}
+
+// ValidateCommand checks the configuration without serving and prints every
+// problem found, prefixed with the file and line it refers to.
+func ValidateCommand(args []string) error {
+	opts := GetArgs("validate", args)
+	cfg, err := opts.LoadConfig()
+	if err != nil {
+		fmt.Println(err)
+		return errors.New("configuration is invalid")
+	}
+
+	failed := false
+	for _, diag := range ValidateConfig(cfg, opts) {
+		fmt.Println(diag)
+		failed = failed || !diag.Warning
+	}
+
+	if failed {
+		return errors.New("configuration is invalid")
+	}
+	fmt.Printf("Configuration OK: %d scripts.\n", len(cfg.Scripts))
+	return nil
+}
+
+// OnceCommand executes every script a single time and prints the resulting
+// metrics in the Prometheus text exposition format to stdout.
+func OnceCommand(args []string) error {
+	opts := GetArgs("once", args)
+	cfg, err := opts.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	var gatherers prometheus.Gatherers
+	for _, sc := range cfg.Scripts {
+		if sc.Probe {
+			continue // Probes need the parameters of a request.
+		}
+		script, err := NewScript(sc)
+		if err != nil {
+			return err
+		}
+
+		if err := script.Run(context.Background()); err != nil {
+			log.Printf("Error executing command %s: %v", sc.Name, err)
+			failed = true
+			continue
+		}
+		gatherers = append(gatherers, script)
+	}
+
+	families, err := gatherers.Gather()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(os.Stdout, family); err != nil {
+			return err
+		}
+	}
+
+	if failed {
+		return errors.New("one or more scripts failed")
+	}
+	return nil
+}
+
+// VersionCommand prints the exporter version.
+func VersionCommand(args []string) error {
+	fmt.Printf("%s version %s (%s %s/%s)\n",
+		filepath.Base(os.Args[0]), Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
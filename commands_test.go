@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAdminAPIRequiresAuth(t *testing.T) {
+	webConfig := filepath.Join(t.TempDir(), "web.yml")
+	if err := os.WriteFile(webConfig, []byte("basic_auth_users:\n  admin: $2y$10$abc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		opts    Options
+		cfg     Config
+		wantErr bool
+	}{
+		{"disabled", Options{}, Config{}, false},
+		{"no auth", Options{EnableAdminAPI: true}, Config{}, true},
+		{"bearer token", Options{EnableAdminAPI: true}, Config{BearerToken: "secret"}, false},
+		{"basic auth", Options{EnableAdminAPI: true}, Config{BasicAuthUsers: map[string]string{"admin": "$2y$10$abc"}}, false},
+		{"client certificates", Options{EnableAdminAPI: true, TLSClientCA: "ca.pem"}, Config{}, false},
+		{"web config", Options{EnableAdminAPI: true, WebConfigFile: webConfig}, Config{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewExporter(&tc.opts).Apply(&tc.cfg)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdminAPIDisabled(t *testing.T) {
+	opts := GetArgs("run", nil)
+	handler, err := NewHandler(opts, NewExporter(opts), prometheus.DefaultGatherer, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/v1/scripts", nil),
+		httptest.NewRequest(http.MethodPost, "/-/reload", nil),
+		httptest.NewRequest(http.MethodPost, "/-/enable?script=a", nil),
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		if w.Code == http.StatusOK {
+			t.Errorf("%s %s served without -web.enable-admin-api", request.Method, request.URL)
+		}
+	}
+}
+
+func TestProbeOpenMetrics(t *testing.T) {
+	opts := GetArgs("run", nil)
+	exporter := NewExporter(opts)
+	err := exporter.Apply(&Config{Scripts: []ScriptConfig{{
+		Name:     "probe",
+		Path:     "echo 'probe_value 1'",
+		Shell:    true,
+		Format:   FormatPrometheus,
+		Interval: Duration(10 * time.Second),
+		Probe:    true,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Stop()
+	handler, err := NewHandler(opts, exporter, prometheus.DefaultGatherer, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/probe?script=probe", nil)
+	request.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("got Content-Type %q, want OpenMetrics", contentType)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// setPart reports whether metric is part of one of the named histograms or
+// summaries. If so, it sets the type and part of metric and renames it to
+// the histogram or summary. Summary quantiles named <name>_p<percentile>
+// get the equivalent quantile label.
+func setPart(metric *Metric, typ string, names []string) bool {
+	if metric.Name == "" {
+		return false
+	}
+	for _, name := range names {
+		part := ""
+		switch suffix, ok := strings.CutPrefix(metric.Name, name); {
+		case !ok:
+			continue
+		case suffix == "":
+		case suffix == "_sum", suffix == "_count":
+			part = suffix[1:]
+		case typ == MetricTypeSummary && strings.HasPrefix(suffix, "_p"):
+			percentile, err := strconv.ParseFloat(suffix[2:], 64)
+			if err != nil || percentile < 0 || percentile > 100 {
+				continue
+			}
+			metric.Labels = maps.Clone(metric.Labels)
+			if metric.Labels == nil {
+				metric.Labels = make(map[string]string)
+			}
+			metric.Labels["quantile"] = strconv.FormatFloat(percentile/100, 'f', -1, 64)
+		default:
+			continue
+		}
+		metric.Name, metric.Type, metric.part = name, typ, part
+		return true
+	}
+	return false
+}
+
+// compositeLabels returns the labels of the histogram or summary metric is
+// part of, i.e. its labels without le or quantile.
+func compositeLabels(metric Metric) map[string]string {
+	labels := metric.AllLabels()
+	delete(labels, "le")
+	delete(labels, "quantile")
+	return labels
+}
+
+// composite is a histogram or summary being assembled from script output.
+type composite struct {
+	name      string
+	typ       string
+	values    []string
+	timestamp time.Time
+	seen      time.Time
+
+	// points holds the cumulative bucket counts of a histogram or the
+	// quantiles of a summary.
+	points   map[float64]float64
+	sum      float64
+	count    uint64
+	hasCount bool
+	inf      uint64
+	hasInf   bool
+}
+
+// compositeBuilder assembles histograms and summaries from the lines of
+// their buckets or quantiles, sums and counts.
+type compositeBuilder struct {
+	order      []string
+	composites map[string]*composite
+}
+
+func newCompositeBuilder() *compositeBuilder {
+	return &compositeBuilder{composites: make(map[string]*composite)}
+}
+
+// add adds a part to its histogram or summary.
+func (b *compositeBuilder) add(metric Metric, schema map[string][]string) error {
+	name, labels := metric.Name, compositeLabels(metric)
+	values := make([]string, len(schema[name]))
+	for i, label := range schema[name] {
+		values[i] = labels[label]
+	}
+
+	key := name + "\xff" + strings.Join(values, "\xff")
+	c, ok := b.composites[key]
+	if !ok {
+		c = &composite{name: name, typ: metric.Type, values: values, seen: metric.seen, points: make(map[float64]float64)}
+		b.composites[key] = c
+		b.order = append(b.order, key)
+	}
+	if !metric.Timestamp.IsZero() {
+		c.timestamp = metric.Timestamp
+	}
+	if metric.seen.IsZero() || !c.seen.IsZero() && metric.seen.After(c.seen) {
+		c.seen = metric.seen
+	}
+
+	isCount := metric.part == "count" || metric.Type == MetricTypeHistogram && metric.part == ""
+	switch {
+	case metric.part == "sum":
+		c.sum = metric.Value
+		return nil
+	case isCount && (metric.Value < 0 || metric.Value != math.Trunc(metric.Value)):
+		return fmt.Errorf("%s %s: count must be a non-negative integer, got %v", metric.Type, name, metric.Value)
+	case metric.part == "count":
+		c.count, c.hasCount = uint64(metric.Value), true
+		return nil
+	}
+
+	label := "le"
+	if metric.Type == MetricTypeSummary {
+		label = "quantile"
+	}
+	point, ok := metric.Labels[label]
+	if !ok {
+		return fmt.Errorf("%s %s: line without %s label", metric.Type, name, label)
+	}
+	bound, err := strconv.ParseFloat(point, 64)
+	if err != nil {
+		return fmt.Errorf("%s %s: invalid %s label %q", metric.Type, name, label, point)
+	}
+	if metric.Type == MetricTypeHistogram && math.IsInf(bound, +1) {
+		c.inf, c.hasInf = uint64(metric.Value), true
+		return nil
+	}
+	c.points[bound] = metric.Value
+	return nil
+}
+
+// build returns the assembled histograms and summaries and when they
+// expire.
+func (b *compositeBuilder) build(descs map[string]*prometheus.Desc, expires func(seen time.Time) time.Time) ([]gaugeSample, error) {
+	samples := make([]gaugeSample, 0, len(b.order))
+	for _, key := range b.order {
+		c := b.composites[key]
+
+		var sample prometheus.Metric
+		var err error
+		if c.typ == MetricTypeSummary {
+			sample, err = prometheus.NewConstSummary(descs[c.name], c.count, c.sum, c.points, c.values...)
+		} else {
+			var buckets map[float64]uint64
+			if buckets, err = c.buckets(); err != nil {
+				return nil, err
+			}
+			sample, err = prometheus.NewConstHistogram(descs[c.name], c.inf, c.sum, buckets, c.values...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", c.name, err)
+		}
+		if !c.timestamp.IsZero() {
+			sample = prometheus.NewMetricWithTimestamp(c.timestamp, sample)
+		}
+		samples = append(samples, gaugeSample{sample, expires(c.seen)})
+	}
+	return samples, nil
+}
+
+// buckets checks that the cumulative bucket counts of a histogram do not
+// decrease as le increases and returns them. A missing _count is taken from
+// the +Inf bucket and a missing +Inf bucket from _count, leaving the count
+// in c.inf.
+func (c *composite) buckets() (map[float64]uint64, error) {
+	switch {
+	case c.hasCount && c.hasInf && c.count != c.inf:
+		return nil, fmt.Errorf("histogram %s: count %d differs from the le=+Inf bucket %d", c.name, c.count, c.inf)
+	case c.hasCount:
+		c.inf = c.count
+	case !c.hasInf:
+		return nil, fmt.Errorf("histogram %s: neither a count nor an le=+Inf bucket", c.name)
+	}
+
+	bounds := slices.Sorted(maps.Keys(c.points))
+	buckets := make(map[float64]uint64, len(bounds))
+	var previous uint64
+	for i, bound := range bounds {
+		count := uint64(c.points[bound])
+		if i > 0 && count < previous {
+			return nil, fmt.Errorf("histogram %s: bucket le=%v has count %d, less than %d of bucket le=%v", c.name, bound, count, previous, bounds[i-1])
+		}
+		buckets[bound], previous = count, count
+	}
+	if previous > c.inf {
+		return nil, fmt.Errorf("histogram %s: bucket le=%v has count %d, more than the count %d", c.name, bounds[len(bounds)-1], previous, c.inf)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCompositeHistogram(t *testing.T) {
+	bucket := func(le string, value float64) Metric {
+		return Metric{Name: "h", Type: MetricTypeHistogram, Labels: map[string]string{"le": le}, Value: value}
+	}
+	count := func(value float64) Metric {
+		return Metric{Name: "h", Type: MetricTypeHistogram, Value: value, part: "count"}
+	}
+	for _, tc := range []struct {
+		name      string
+		metrics   []Metric
+		wantCount uint64
+		wantErr   bool
+	}{
+		{"count from +Inf", []Metric{bucket("1", 2), bucket("2", 3), bucket("+Inf", 5)}, 5, false},
+		{"+Inf from count", []Metric{bucket("1", 2), bucket("2", 3), count(4)}, 4, false},
+		{"count and +Inf agree", []Metric{bucket("1", 2), bucket("+Inf", 4), count(4)}, 4, false},
+		{"count and +Inf differ", []Metric{bucket("1", 2), bucket("+Inf", 4), count(5)}, 0, true},
+		{"decreasing buckets", []Metric{bucket("2", 2), bucket("1", 3), bucket("+Inf", 5)}, 0, true},
+		{"bucket above count", []Metric{bucket("1", 6), count(5)}, 0, true},
+		{"no count", []Metric{bucket("1", 2)}, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := newCompositeBuilder()
+			for _, metric := range tc.metrics {
+				if err := builder.add(metric, map[string][]string{"h": nil}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			descs := map[string]*prometheus.Desc{"h": prometheus.NewDesc("h", "h", nil, nil)}
+			samples, err := builder.build(descs, func(seen time.Time) time.Time { return seen })
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var m dto.Metric
+			if err := samples[0].Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.GetHistogram().GetSampleCount(); got != tc.wantCount {
+				t.Errorf("got count %d, want %d", got, tc.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,1011 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultInterval is used for scripts that do not set their own interval
+// when the configuration sets no global interval either.
+const DefaultInterval = 60 * time.Second
+
+// MinBackoff is the delay before retrying a failed script for the first
+// time. It doubles with every consecutive failure up to BackoffMax.
+const MinBackoff = 5 * time.Second
+
+// DefaultQuarantineProbe is how often quarantined scripts are tried again
+// unless they set QuarantineProbe.
+const DefaultQuarantineProbe = 15 * time.Minute
+
+// Config represents the structure of the exporter configuration file. The
+// file is YAML, or TOML if its name ends in .toml, with the same keys.
+type Config struct {
+	Scripts []ScriptConfig `yaml:"scripts"`
+
+	// Interval is the default collection interval of scripts that do not
+	// set their own.
+	Interval Duration `yaml:"interval"`
+
+	// StartDelay is the default StartDelay of scripts that do not set
+	// their own.
+	StartDelay Duration `yaml:"start_delay"`
+
+	// Include lists glob patterns of further files whose scripts are added
+	// to this configuration. Relative patterns are resolved against the
+	// directory of the configuration file. Wildcards may also be used in
+	// directory names, as in conf.d/*/scripts.yml.
+	Include []string `yaml:"include"`
+
+	// GraphiteMappings translate the paths received by the Graphite
+	// listener into metric names and labels. The first match applies.
+	GraphiteMappings []GraphiteMapping `yaml:"graphite_mappings"`
+
+	// Labels are added to every metric of every script, unless the script
+	// sets a label of the same name, and to the StatsD and Graphite metrics.
+	Labels map[string]string `yaml:"labels"`
+
+	// MaxConcurrentRuns limits the number of scripts running at the same
+	// time. Zero means no limit.
+	MaxConcurrentRuns int `yaml:"max_concurrent_runs"`
+
+	// MonTypeTypes is the default MonTypeTypes of scripts, which override
+	// individual entries.
+	MonTypeTypes map[string]string `yaml:"mon_type_types"`
+
+	// RelabelConfigs are applied to the metrics of every script after its
+	// own.
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs"`
+
+	// BasicAuthUsers maps user names to the bcrypt hashes of their
+	// passwords. When set, the HTTP endpoints require basic auth, as set
+	// by the basic_auth of a Prometheus scrape configuration.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+
+	// BearerToken, or the contents of BearerTokenFile read on every
+	// request, is also accepted as an "Authorization: Bearer" header.
+	BearerToken     string `yaml:"bearer_token"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+
+	// MetricOptions are the defaults of scripts that do not set their own.
+	MetricOptions `yaml:",inline"`
+}
+
+// MetricOptions describe the metrics exported for script output.
+type MetricOptions struct {
+	// Namespace and Subsystem prefix the name of metrics without a name of
+	// their own. They default to "prom" and "custom"; an empty string
+	// omits them.
+	Namespace *string `yaml:"namespace"`
+	Subsystem *string `yaml:"subsystem"`
+
+	// Help is the help text of the exported metrics. A metric exported by
+	// several scripts gets the help of the first one, by name, setting it.
+	Help string `yaml:"help"`
+}
+
+// mergeLabels returns the union of both label sets, with labels winning over
+// defaults.
+func mergeLabels(defaults, labels map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return labels
+	}
+	merged := maps.Clone(defaults)
+	maps.Copy(merged, labels)
+	return merged
+}
+
+// inherit fills in the options not set from defaults.
+func (o *MetricOptions) inherit(defaults MetricOptions) {
+	if o.Namespace == nil {
+		o.Namespace = defaults.Namespace
+	}
+	if o.Subsystem == nil {
+		o.Subsystem = defaults.Subsystem
+	}
+	if o.Help == "" {
+		o.Help = defaults.Help
+	}
+}
+
+// MetricName returns the name a metric is exported as. Only the default
+// metric is affected by Namespace and Subsystem.
+func (o MetricOptions) MetricName(name string) string {
+	suffix, ok := strings.CutPrefix(name, DefaultMetricName)
+	if !ok || suffix != "" && suffix != "_total" {
+		return name
+	}
+	namespace, subsystem := "prom", "custom"
+	if o.Namespace != nil {
+		namespace = *o.Namespace
+	}
+	if o.Subsystem != nil {
+		subsystem = *o.Subsystem
+	}
+	return prometheus.BuildFQName(namespace, subsystem, "custom_metrics") + suffix
+}
+
+// MetricHelp returns the help text of the exported metrics.
+func (o MetricOptions) MetricHelp() string {
+	if o.Help == "" {
+		return "Custom metrics from script execution"
+	}
+	return o.Help
+}
+
+// ScriptConfig describes a single script to be executed by the exporter.
+type ScriptConfig struct {
+	Name     string            `yaml:"name"`
+	Path     string            `yaml:"path"`
+	Args     []string          `yaml:"args"`
+	Labels   map[string]string `yaml:"labels"`
+	Interval Duration          `yaml:"interval"`
+
+	// MetricOptions override the global ones for this script.
+	MetricOptions `yaml:",inline"`
+
+	// Format selects the parser for the script output: "csv" (default),
+	// "json" for one JSON object per line, "labels" for lines naming their
+	// own metric and labels, "regex" for free-form output matched by
+	// Pattern, "jsonpath" or "xpath" for a JSON or XML document queried by
+	// JSONPath or XPath, "nagios" for Nagios plugins, "influx" for the
+	// InfluxDB line protocol, "collectd" for collectd exec plugins, or
+	// "prometheus" for the text exposition format, which is passed through
+	// with Labels added.
+	Format string `yaml:"format"`
+
+	// Schedule is a cron expression ("5 2 * * *", "@hourly") used instead of
+	// Interval. Prefix it with CRON_TZ=<zone> to use another time zone.
+	Schedule string `yaml:"schedule"`
+
+	// Jitter delays the first run by a random duration of up to Jitter, or
+	// every run of a Schedule, so that hosts started together do not run
+	// their scripts in lockstep.
+	Jitter Duration `yaml:"jitter"`
+
+	// StartDelay delays the first run after the exporter starts, or the
+	// script is reloaded, e.g. to keep heavy scripts out of a boot storm.
+	// Scripts run right away by default; set 0s to override a global
+	// start_delay.
+	StartDelay *Duration `yaml:"start_delay"`
+
+	// DependsOn names scripts that run before this one: a run waits for
+	// the runs of these scripts that are due at the same time or already
+	// in progress, e.g. to let a cache refresh finish before the scripts
+	// reporting from the cache.
+	DependsOn []string `yaml:"depends_on"`
+
+	// BackoffMax caps the delay before retrying a failing script, which
+	// starts at 5s and doubles with every consecutive failure. It defaults
+	// to Interval; set it higher to run broken scripts less often.
+	BackoffMax Duration `yaml:"backoff_max"`
+
+	// QuarantineAfter quarantines the script after this many consecutive
+	// failures: it is then only probed every QuarantineProbe (15m by
+	// default) until a run succeeds or it is enabled again through
+	// /-/enable. 0 never quarantines the script.
+	QuarantineAfter int      `yaml:"quarantine_after"`
+	QuarantineProbe Duration `yaml:"quarantine_probe"`
+
+	// OnScrape runs the script whenever the metrics are scraped instead of
+	// in the background, so that the values are always fresh. A run taking
+	// longer than Interval is killed and the previous values are served.
+	OnScrape bool `yaml:"on_scrape"`
+
+	// Probe makes the script run only on requests to
+	// /probe?script=<name>, like a blackbox exporter module, instead of in
+	// the background; its metrics are not exported on the metrics path.
+	// The query parameters listed in ProbeParams are passed to the script
+	// as PROBE_<PARAM> environment variables, e.g. PROBE_TARGET for
+	// target; requests with other parameters are rejected.
+	Probe       bool     `yaml:"probe"`
+	ProbeParams []string `yaml:"probe_params"`
+
+	// CacheFor serves the result of a run on scrape to the scrapes within
+	// this duration of it instead of running the script again, e.g. when
+	// several Prometheus servers scrape the exporter.
+	CacheFor Duration `yaml:"cache_for"`
+
+	// Shell runs Path as a command line through "sh -c", allowing pipes and
+	// globbing. Args are then available as $1, $2, ...
+	Shell bool `yaml:"shell"`
+
+	// Env holds extra environment variables for the script. Values may
+	// reference the exporter's own environment as ${VAR}; use $$ for a
+	// literal dollar sign.
+	Env map[string]string `yaml:"env"`
+
+	// WorkDir is the working directory of the script. Relative paths in
+	// Path are resolved against it.
+	WorkDir string `yaml:"workdir"`
+
+	// Interpreter launches the script through another program, e.g.
+	// "python3" or "pwsh -NoProfile -File". Path and Args are appended.
+	Interpreter string `yaml:"interpreter"`
+
+	// Position is the file and line the script was defined at.
+	Position string `yaml:"-"`
+
+	// InheritEnv passes the exporter's full environment to the script. By
+	// default only PATH and Env are passed.
+	InheritEnv bool `yaml:"inherit_env"`
+
+	// Header makes the first line of CSV output name the columns, so that
+	// scripts choose their own labels and column order.
+	Header bool `yaml:"header"`
+
+	// Delimiter separates the fields of CSV output, e.g. "|" or "\t".
+	// Defaults to a comma.
+	Delimiter string `yaml:"delimiter"`
+
+	// Pattern is the regular expression of the "regex" format. Its named
+	// groups provide the labels and the required "value" group the value.
+	Pattern string `yaml:"pattern"`
+
+	// JSONPath lists the metrics extracted by the "jsonpath" format.
+	JSONPath []JSONPathMetric `yaml:"jsonpath"`
+
+	// XPath lists the metrics extracted by the "xpath" format.
+	XPath []XPathMetric `yaml:"xpath"`
+
+	// OnParseError is "fail" (default) to discard a run with malformed
+	// output lines, or "skip" to export the valid lines regardless.
+	OnParseError string `yaml:"on_parse_error"`
+
+	// OnInvalidValue handles empty, non-numeric, NaN and infinite values:
+	// "fail" (default) treats non-numeric values as malformed lines, "drop"
+	// drops the sample, "nan" exports NaN and "default" exports
+	// DefaultValue.
+	OnInvalidValue string  `yaml:"on_invalid_value"`
+	DefaultValue   float64 `yaml:"default_value"`
+
+	// OnFailure is "keep" (default) to keep exporting the values of the
+	// last successful run when a run fails, or "stale" to also export
+	// custom_exporter_script_data_stale and the age of the values.
+	OnFailure string `yaml:"on_failure"`
+
+	// OnDuplicate handles series printed more than once in a run: "last"
+	// (default) or "first" keeps one of the values, "sum" adds them up and
+	// "fail" rejects the run.
+	OnDuplicate string `yaml:"on_duplicate"`
+
+	// MaxLineBytes limits the length of a single output line. Defaults to
+	// DefaultMaxLineBytes.
+	MaxLineBytes int `yaml:"max_line_bytes"`
+
+	// MaxOutputBytes limits the total output of a run. Zero means no limit.
+	MaxOutputBytes int `yaml:"max_output_bytes"`
+
+	// MaxLines limits the number of output lines parsed; the lines beyond
+	// are ignored and counted. Zero means no limit. Not supported by the
+	// jsonpath and xpath formats, whose documents cannot be cut short, nor
+	// by collectd, whose output never ends.
+	MaxLines int `yaml:"max_lines"`
+
+	// Counters lists the metrics exported as counters instead of gauges, by
+	// metric name or, for lines without a name, by mon_type. The latter are
+	// exported as DefaultMetricName with a _total suffix.
+	Counters []string `yaml:"counters"`
+
+	// MonTypeTypes maps mon_type values to the type of their metrics:
+	// "gauge", "counter" (as if listed in Counters) or "histogram" (as a
+	// native histogram of the values, named like the mon_type).
+	MonTypeTypes map[string]string `yaml:"mon_type_types"`
+
+	// Histograms lists the names of metrics assembled into histograms. Each
+	// bucket is a line of the metric with an "le" label holding the
+	// cumulative count; optional <name>_sum and <name>_count lines provide
+	// the sum and count.
+	Histograms []string `yaml:"histograms"`
+
+	// Summaries lists the names of metrics exported as summaries. Each
+	// quantile is a line of the metric with a "quantile" label, or a
+	// <name>_p<percentile> line such as <name>_p95; optional <name>_sum and
+	// <name>_count lines provide the sum and count.
+	Summaries []string `yaml:"summaries"`
+
+	// RelabelConfigs rewrite or drop the parsed metrics before they are
+	// exported.
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs"`
+
+	// KeepLabels, if set, lists the only labels exported; DropLabels lists
+	// labels removed before export. Both apply to the fixed fields as well,
+	// e.g. to drop a high-cardinality process_name.
+	KeepLabels []string `yaml:"keep_labels"`
+	DropLabels []string `yaml:"drop_labels"`
+
+	// Transforms convert values to base units before export.
+	Transforms []ValueTransform `yaml:"transforms"`
+
+	// Derived lists metrics computed from the parsed ones.
+	Derived []DerivedMetric `yaml:"derived"`
+
+	// Aggregations export aggregates of the parsed metrics.
+	Aggregations []Aggregation `yaml:"aggregations"`
+
+	// NativeHistograms aggregate metrics reporting single observations into
+	// native histograms.
+	NativeHistograms []NativeHistogram `yaml:"native_histograms"`
+
+	// Deltas and Rates list metrics reporting ever-growing totals, by name
+	// or, for lines without a name, by mon_type. For each, the increase
+	// since the previous run is exported with a _delta suffix, or the
+	// increase per second with a _rate suffix. A decrease is taken as a
+	// reset of the total to zero.
+	Deltas []string `yaml:"deltas"`
+	Rates  []string `yaml:"rates"`
+
+	// StateSets declare metrics reporting one of several named states.
+	StateSets []StateSet `yaml:"state_sets"`
+
+	// ExpireAfter keeps exporting series the script stopped reporting, and
+	// the series of failed runs, until they were last reported this long
+	// ago. Zero replaces all series on every successful run and keeps them
+	// when a run fails.
+	ExpireAfter Duration `yaml:"expire_after"`
+
+	// MaxSeries limits the number of series exported. The series beyond
+	// the limit, in order of their names and labels, are dropped. Zero means
+	// no limit.
+	MaxSeries int `yaml:"max_series"`
+}
+
+// Equal reports whether two script definitions are the same, ignoring
+// where in the configuration they were defined.
+func (sc ScriptConfig) Equal(other ScriptConfig) bool {
+	sc.Position, other.Position = "", ""
+	return reflect.DeepEqual(sc, other)
+}
+
+// SetType sets the type metric is exported as. Metrics that are part of a
+// histogram or summary are renamed to it.
+func (sc ScriptConfig) SetType(metric *Metric) {
+	if setPart(metric, MetricTypeHistogram, sc.Histograms) || setPart(metric, MetricTypeSummary, sc.Summaries) {
+		return
+	}
+	metric.Type = sc.metricType(*metric)
+}
+
+// metricType returns the type of a metric that is not part of a histogram
+// or summary.
+func (sc ScriptConfig) metricType(metric Metric) string {
+	key := metric.Name
+	if key == "" {
+		key = metric.MonType
+	}
+	if slices.Contains(sc.Counters, key) {
+		return MetricTypeCounter
+	}
+	return MetricTypeGauge
+}
+
+// FirstRunDelay returns how long to wait before the first run of a script
+// with an interval: its StartDelay plus its jitter.
+func (sc ScriptConfig) FirstRunDelay() time.Duration {
+	delay := sc.JitterDelay()
+	if sc.StartDelay != nil {
+		delay += time.Duration(*sc.StartDelay)
+	}
+	return delay
+}
+
+// JitterDelay returns a random delay of up to Jitter.
+func (sc ScriptConfig) JitterDelay() time.Duration {
+	if sc.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(time.Duration(sc.Jitter))
+}
+
+// Backoff returns the delay before retrying after the given number of
+// consecutive failures.
+func (sc ScriptConfig) Backoff(failures int) time.Duration {
+	limit := time.Duration(sc.BackoffMax)
+	if limit <= 0 {
+		limit = time.Duration(sc.Interval)
+	}
+	backoff := MinBackoff
+	for i := 1; i < failures && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
+
+// ProbeInterval returns how often the script is run while quarantined.
+func (sc ScriptConfig) ProbeInterval() time.Duration {
+	if sc.QuarantineProbe > 0 {
+		return time.Duration(sc.QuarantineProbe)
+	}
+	return DefaultQuarantineProbe
+}
+
+// CronSchedule returns the parsed Schedule, or nil if the script runs at a
+// fixed interval.
+func (sc ScriptConfig) CronSchedule() cron.Schedule {
+	if sc.Schedule == "" {
+		return nil
+	}
+	schedule, err := cron.ParseStandard(sc.Schedule)
+	if err != nil {
+		return nil
+	}
+	return schedule
+}
+
+// ScriptPath returns the location of the script on disk, taking WorkDir
+// into account.
+func (sc ScriptConfig) ScriptPath() string {
+	if sc.WorkDir != "" && !filepath.IsAbs(sc.Path) {
+		return filepath.Join(sc.WorkDir, sc.Path)
+	}
+	return sc.Path
+}
+
+// Environ returns the environment of the script process.
+func (sc ScriptConfig) Environ() []string {
+	var env []string
+	if sc.InheritEnv {
+		env = os.Environ()
+	} else if path, ok := os.LookupEnv("PATH"); ok {
+		env = append(env, "PATH="+path)
+	}
+	if sc.Format == FormatCollectd {
+		// Set up the environment of the collectd exec plugin.
+		hostname, _ := os.Hostname()
+		interval := time.Duration(sc.Interval).Seconds()
+		env = append(env, "COLLECTD_HOSTNAME="+hostname, "COLLECTD_INTERVAL="+strconv.FormatFloat(interval, 'f', -1, 64))
+	}
+
+	keys := make([]string, 0, len(sc.Env))
+	for key := range sc.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+sc.Env[key])
+	}
+	return env
+}
+
+// ExpandEnv substitutes ${VAR} references in s from the exporter's
+// environment and replaces $$ with a single dollar sign. Any other dollar
+// sign, as in $VAR, is kept as is. Referencing an unset variable is an
+// error.
+func ExpandEnv(s string) (string, error) {
+	var b strings.Builder
+	var missing []string
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			name := s[i+2 : i+end]
+			if name == "" {
+				return "", fmt.Errorf("empty ${} in %q", s)
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			b.WriteString(value)
+			s = s[i+end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined environment variable %s", strings.Join(missing, ", "))
+	}
+	return b.String(), nil
+}
+
+// Duration is a time.Duration that is read either as a plain number of
+// seconds or as a Go duration string such as "30s" or "1h30m". It is used in
+// the configuration file as well as for command line flags.
+type Duration time.Duration
+
+// ParseDuration parses a number of seconds or a Go duration string.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if seconds, err := strconv.Atoi(s); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use seconds (e.g. 30) or a duration such as 30s, 5m or 1h30m", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+	}
+	return d, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(s string) error {
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// String implements flag.Value.
+func (d *Duration) String() string {
+	return time.Duration(*d).String()
+}
+
+// labelNameRE matches valid Prometheus label names.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricNameRE matches valid Prometheus metric names.
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// SanitizeMetricName turns a metric name read from script output into a
+// valid one by replacing invalid characters with underscores, e.g.
+// "backup-age.seconds" becomes "backup_age_seconds".
+func SanitizeMetricName(name string) (string, error) {
+	sanitized := strings.Map(func(r rune) rune {
+		if r == ':' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	switch {
+	case !metricNameRE.MatchString(sanitized):
+		return "", fmt.Errorf("invalid metric name %q", name)
+	case strings.HasPrefix(sanitized, "__"):
+		return "", fmt.Errorf("invalid metric name %q: names starting with __ are reserved", name)
+	}
+	return sanitized, nil
+}
+
+// CheckLabelName checks that name is a valid, non-reserved label name.
+func CheckLabelName(name string) error {
+	switch {
+	case !labelNameRE.MatchString(name):
+		return fmt.Errorf("invalid label name %q: must match %s", name, labelNameRE)
+	case strings.HasPrefix(name, "__"):
+		return fmt.Errorf("invalid label name %q: names starting with __ are reserved", name)
+	}
+	return nil
+}
+
+// ValidateLabelName checks that name can be used as a static label.
+func ValidateLabelName(name string) error {
+	if err := CheckLabelName(name); err != nil {
+		return err
+	}
+	for _, reserved := range OutputLabels {
+		if name == reserved {
+			return fmt.Errorf("label %q collides with a label read from the script output", name)
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the configuration file at path and fills in defaults.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return ParseConfig(path, data)
+}
+
+// ParseConfig parses a configuration document read from path and fills in
+// defaults. All problems found in the script definitions are reported
+// together, each prefixed with its file and line.
+func ParseConfig(path string, data []byte) (*Config, error) {
+	cfg, err := decodeConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range cfg.Include {
+		files, err := filepath.Glob(IncludePattern(path, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read included config: %w", err)
+			}
+			included, err := decodeConfig(file, data)
+			if err != nil {
+				return nil, err
+			}
+			if len(included.Include) > 0 {
+				return nil, fmt.Errorf("included config %s must not include further files", file)
+			}
+			// An interval and metric options in an included file are the
+			// defaults for its scripts.
+			for i := range included.Scripts {
+				if included.Scripts[i].Interval <= 0 {
+					included.Scripts[i].Interval = included.Interval
+				}
+				included.Scripts[i].inherit(included.MetricOptions)
+				included.Scripts[i].Labels = mergeLabels(included.Labels, included.Scripts[i].Labels)
+				included.Scripts[i].RelabelConfigs = append(slices.Clip(included.Scripts[i].RelabelConfigs), included.RelabelConfigs...)
+			}
+			cfg.Scripts = append(cfg.Scripts, included.Scripts...)
+		}
+	}
+
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// IncludePattern resolves an include pattern relative to the configuration
+// file it appears in. Patterns in configurations that are not read from
+// local files are used as they are.
+func IncludePattern(configPath, pattern string) string {
+	if filepath.IsAbs(pattern) || strings.Contains(configPath, "://") {
+		return pattern
+	}
+	return filepath.Join(filepath.Dir(configPath), pattern)
+}
+
+// decodeConfig decodes a single configuration document and records the
+// position of every script. An empty document yields an empty config.
+func decodeConfig(path string, data []byte) (*Config, error) {
+	lines := scriptLines(data)
+	if isTOML(path) {
+		lines = tomlScriptLines(data)
+	}
+	data, err := configDocument(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	for i := range cfg.Scripts {
+		cfg.Scripts[i].Position = path
+		if i < len(lines) {
+			cfg.Scripts[i].Position = fmt.Sprintf("%s:%d", path, lines[i])
+		}
+	}
+	return &cfg, nil
+}
+
+// isTOML reports whether the configuration file at path is written in TOML
+// rather than YAML, which is told by its .toml extension.
+func isTOML(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".toml")
+}
+
+// configDocument returns the configuration document read from path as
+// YAML. TOML documents are converted, so that both formats are decoded and
+// checked alike.
+func configDocument(path string, data []byte) ([]byte, error) {
+	if !isTOML(path) {
+		return data, nil
+	}
+	var doc map[string]any
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return yaml.Marshal(doc)
+}
+
+// tomlScriptLines returns the line number of each [[scripts]] table.
+// Scripts given as inline tables are not included.
+func tomlScriptLines(data []byte) []int {
+	var lines []int
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "[[scripts]]" {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// scriptLines returns the line number of each entry in the scripts list.
+func scriptLines(data []byte) []int {
+	var root struct {
+		Scripts []yaml.Node `yaml:"scripts"`
+	}
+	_ = yaml.Unmarshal(data, &root)
+
+	lines := make([]int, len(root.Scripts))
+	for i, node := range root.Scripts {
+		lines[i] = node.Line
+	}
+	return lines
+}
+
+// setDefaults fills in default values and checks the script definitions.
+func (cfg *Config) setDefaults() error {
+	var errs []error
+	if cfg.MaxConcurrentRuns < 0 {
+		errs = append(errs, fmt.Errorf("max_concurrent_runs must not be negative"))
+	}
+	if cfg.StartDelay < 0 {
+		errs = append(errs, fmt.Errorf("start_delay must not be negative"))
+	}
+	for name := range cfg.Labels {
+		if err := CheckLabelName(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := range cfg.RelabelConfigs {
+		if err := cfg.RelabelConfigs[i].compile(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for user, hash := range cfg.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			errs = append(errs, fmt.Errorf("basic_auth_users: %s: invalid bcrypt hash: %w", user, err))
+		}
+	}
+	if cfg.BearerToken != "" && cfg.BearerTokenFile != "" {
+		errs = append(errs, fmt.Errorf("bearer_token and bearer_token_file are mutually exclusive"))
+	}
+	for i := range cfg.GraphiteMappings {
+		if err := cfg.GraphiteMappings[i].compile(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	seen := make(map[string]string)
+	for i := range cfg.Scripts {
+		sc := &cfg.Scripts[i]
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("%s: script %q: %s", sc.Position, sc.Name, fmt.Sprintf(format, args...)))
+		}
+
+		if sc.Path == "" {
+			fail("path is required")
+		}
+		if sc.Name == "" && !sc.Shell {
+			sc.Name = filepath.Base(sc.Path)
+		}
+		if sc.Name == "" {
+			fail("name is required in shell mode")
+		} else if other, ok := seen[sc.Name]; ok {
+			fail("duplicate name, first defined at %s", other)
+		} else {
+			seen[sc.Name] = sc.Position
+		}
+		if sc.Shell && sc.Interpreter != "" {
+			fail("shell and interpreter are mutually exclusive")
+		}
+		if sc.Format != FormatPrometheus {
+			if _, err := NewParser(*sc); err != nil {
+				fail("%v", err)
+			}
+		}
+		if sc.Format != "" && sc.Format != "csv" {
+			if sc.Header {
+				fail("header requires the csv format")
+			}
+			if sc.Delimiter != "" {
+				fail("delimiter requires the csv format")
+			}
+		}
+		if sc.Pattern != "" && sc.Format != "regex" {
+			fail("pattern requires the regex format")
+		}
+		if sc.JSONPath != nil && sc.Format != FormatJSONPath {
+			fail("jsonpath requires the jsonpath format")
+		}
+		if sc.XPath != nil && sc.Format != FormatXPath {
+			fail("xpath requires the xpath format")
+		}
+		switch sc.OnParseError {
+		case "", ParseErrorFail, ParseErrorSkip:
+		default:
+			fail("on_parse_error must be %q or %q", ParseErrorFail, ParseErrorSkip)
+		}
+		switch sc.OnFailure {
+		case "", FailureKeep, FailureStale:
+		default:
+			fail("on_failure must be %q or %q", FailureKeep, FailureStale)
+		}
+		switch sc.OnDuplicate {
+		case "", DuplicateLast, DuplicateFirst, DuplicateSum, DuplicateFail:
+		default:
+			fail("on_duplicate must be one of %q, %q, %q or %q", DuplicateLast, DuplicateFirst, DuplicateSum, DuplicateFail)
+		}
+		switch sc.OnInvalidValue {
+		case "", InvalidValueFail, InvalidValueDrop, InvalidValueNaN, InvalidValueDefault:
+		default:
+			fail("on_invalid_value must be one of %q, %q, %q or %q",
+				InvalidValueFail, InvalidValueDrop, InvalidValueNaN, InvalidValueDefault)
+		}
+		if sc.Jitter < 0 {
+			fail("jitter must not be negative")
+		}
+		if sc.StartDelay == nil && cfg.StartDelay > 0 {
+			delay := cfg.StartDelay
+			sc.StartDelay = &delay
+		}
+		if sc.StartDelay != nil && *sc.StartDelay < 0 {
+			fail("start_delay must not be negative")
+		}
+		if sc.BackoffMax < 0 {
+			fail("backoff_max must not be negative")
+		}
+		if sc.QuarantineAfter < 0 || sc.QuarantineProbe < 0 {
+			fail("quarantine_after and quarantine_probe must not be negative")
+		}
+		if sc.QuarantineAfter > 0 && (sc.Schedule != "" || sc.OnScrape) {
+			fail("quarantine_after is not supported with schedule or on_scrape")
+		}
+		if sc.ExpireAfter < 0 {
+			fail("expire_after must not be negative")
+		}
+		if sc.MaxSeries < 0 {
+			fail("max_series must not be negative")
+		}
+		if sc.MaxLineBytes < 0 || sc.MaxOutputBytes < 0 {
+			fail("max_line_bytes and max_output_bytes must not be negative")
+		}
+		if sc.MaxLines < 0 {
+			fail("max_lines must not be negative")
+		}
+		if sc.MaxLines > 0 && (sc.Format == FormatJSONPath || sc.Format == FormatXPath || sc.Format == FormatCollectd) {
+			fail("max_lines is not supported by the %s format", sc.Format)
+		}
+		if sc.OnScrape && sc.Schedule != "" {
+			fail("on_scrape and schedule are mutually exclusive")
+		}
+		if sc.CacheFor < 0 || sc.CacheFor > 0 && !sc.OnScrape {
+			fail("cache_for must be positive and requires on_scrape")
+		}
+		if sc.Probe {
+			if sc.OnScrape || sc.Schedule != "" || sc.Format == FormatCollectd {
+				fail("probe is not supported with on_scrape, schedule or the collectd format")
+			}
+			if sc.QuarantineAfter > 0 || len(sc.DependsOn) > 0 {
+				fail("probe is not supported with quarantine_after or depends_on")
+			}
+		}
+		for _, param := range sc.ProbeParams {
+			if !sc.Probe {
+				fail("probe_params requires probe")
+				break
+			}
+			if param == "script" || !labelNameRE.MatchString(param) {
+				fail("invalid probe parameter %q", param)
+			}
+		}
+		if sc.OnScrape && sc.Format == FormatCollectd {
+			fail("on_scrape is not supported by the collectd format")
+		}
+		if sc.Schedule != "" {
+			if sc.Interval > 0 {
+				fail("interval and schedule are mutually exclusive")
+			}
+			if _, err := cron.ParseStandard(sc.Schedule); err != nil {
+				fail("invalid schedule %q: %v", sc.Schedule, err)
+			}
+		}
+		if sc.Interval <= 0 {
+			sc.Interval = cfg.Interval
+		}
+		if sc.Interval <= 0 {
+			sc.Interval = Duration(DefaultInterval)
+		}
+		sc.inherit(cfg.MetricOptions)
+		if name := sc.MetricName(DefaultMetricName); !metricNameRE.MatchString(name) {
+			fail("namespace and subsystem give invalid metric name %q", name)
+		}
+		sc.Labels = mergeLabels(cfg.Labels, sc.Labels)
+		for i := range sc.RelabelConfigs {
+			if err := sc.RelabelConfigs[i].compile(); err != nil {
+				fail("%v", err)
+			}
+		}
+		sc.RelabelConfigs = append(slices.Clip(sc.RelabelConfigs), cfg.RelabelConfigs...)
+		if len(sc.KeepLabels) > 0 && len(sc.DropLabels) > 0 {
+			fail("keep_labels and drop_labels are mutually exclusive")
+		}
+		for _, t := range sc.Transforms {
+			if err := t.check(); err != nil {
+				fail("%v", err)
+			}
+		}
+		sc.MonTypeTypes = mergeLabels(cfg.MonTypeTypes, sc.MonTypeTypes)
+		for _, monType := range slices.Sorted(maps.Keys(sc.MonTypeTypes)) {
+			switch typ := sc.MonTypeTypes[monType]; typ {
+			case MetricTypeGauge:
+			case MetricTypeCounter:
+				if !slices.Contains(sc.Counters, monType) {
+					sc.Counters = append(sc.Counters, monType)
+				}
+			case MetricTypeHistogram:
+				if !slices.ContainsFunc(sc.NativeHistograms, func(h NativeHistogram) bool { return h.Metric == monType }) {
+					sc.NativeHistograms = append(sc.NativeHistograms, NativeHistogram{Metric: monType})
+				}
+			default:
+				fail("mon_type_types: unknown type %q for %q", typ, monType)
+			}
+		}
+		for _, h := range sc.NativeHistograms {
+			if err := h.check(); err != nil {
+				fail("%v", err)
+			}
+		}
+		for _, a := range sc.Aggregations {
+			if err := a.check(); err != nil {
+				fail("%v", err)
+			}
+		}
+		for i := range sc.Derived {
+			if err := sc.Derived[i].compile(); err != nil {
+				fail("%v", err)
+			}
+		}
+		for name := range sc.Labels {
+			if err := ValidateLabelName(name); err != nil {
+				fail("%v", err)
+			}
+		}
+		for key, value := range sc.Env {
+			expanded, err := ExpandEnv(value)
+			if err != nil {
+				fail("env %s: %v", key, err)
+			}
+			sc.Env[key] = expanded
+		}
+	}
+	errs = append(errs, checkDependencies(cfg.Scripts)...)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEnvironInheritEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin:/bin")
+	t.Setenv("EXPORTER_SECRET", "hunter2")
+	sc := ScriptConfig{Env: map[string]string{"DB_HOST": "db"}}
+	if got, want := sc.Environ(), []string{"PATH=/usr/bin:/bin", "DB_HOST=db"}; !slices.Equal(got, want) {
+		t.Errorf("by default got %q, want %q", got, want)
+	}
+
+	sc.InheritEnv = true
+	got := sc.Environ()
+	if !slices.Contains(got, "EXPORTER_SECRET=hunter2") || !slices.Contains(got, "DB_HOST=db") {
+		t.Errorf("with inherit_env got %q, want the exporter's environment and DB_HOST", got)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("DB_HOST", "db")
+	for s, want := range map[string]string{
+		"${DB_HOST}:5432": "db:5432",
+		"$DB_HOST":        "$DB_HOST",
+		"$$DB_HOST":       "$DB_HOST",
+		"$${DB_HOST}":     "${DB_HOST}",
+		"pa$word$":        "pa$word$",
+		"a$$$":            "a$$",
+	} {
+		got, err := ExpandEnv(s)
+		if err != nil || got != want {
+			t.Errorf("ExpandEnv(%q) = %q, %v; want %q", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"${CUSTOM_EXPORTER_UNSET}", "${DB_HOST", "${}"} {
+		if _, err := ExpandEnv(s); err == nil {
+			t.Errorf("ExpandEnv(%q): expected an error", s)
+		}
+	}
+}
+
+func TestParseConfigTOML(t *testing.T) {
+	data := []byte(`interval = "30s"
+
+[[scripts]]
+name = "disk"
+path = "/usr/local/bin/disk.sh"
+
+[scripts.labels]
+team = "storage"
+
+[[scripts]]
+name = "load"
+path = "/usr/local/bin/load.sh"
+interval = 15
+`)
+	cfg, err := ParseConfig("exporter.toml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Scripts) != 2 {
+		t.Fatalf("got %d scripts, want 2", len(cfg.Scripts))
+	}
+	disk, load := cfg.Scripts[0], cfg.Scripts[1]
+	if disk.Labels["team"] != "storage" || disk.Interval != Duration(30*time.Second) || disk.Position != "exporter.toml:3" {
+		t.Errorf("got %+v", disk)
+	}
+	if load.Interval != Duration(15*time.Second) || load.Position != "exporter.toml:10" {
+		t.Errorf("got %+v", load)
+	}
+
+	if _, err := ParseConfig("exporter.toml", []byte("[[scripts]]\nname = \"x\"\npaht = \"/bin/true\"\n")); err == nil {
+		t.Error("unknown key accepted")
+	}
+}
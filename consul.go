@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConsulSource reads the configuration from a Consul KV key and watches it
+// with blocking queries. The ACL token is taken from CONSUL_HTTP_TOKEN.
+type ConsulSource struct {
+	scheme string
+	addr   string
+	key    string
+	token  string
+	client *http.Client
+
+	mu    sync.Mutex
+	index uint64
+}
+
+// consulWait is how long a blocking query waits for the key to change.
+const consulWait = 5 * time.Minute
+
+// NewConsulSource returns a source for key on the Consul agent at addr,
+// connecting as described by the query parameters of the config URL.
+func NewConsulSource(addr, key string, query url.Values) (*ConsulSource, error) {
+	if addr == "" {
+		addr = "127.0.0.1:8500"
+	}
+	scheme, transport, err := sourceTransport(query)
+	if err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+	return &ConsulSource{
+		scheme: scheme,
+		addr:   addr,
+		key:    key,
+		token:  os.Getenv("CONSUL_HTTP_TOKEN"),
+		// Consul adds up to a sixteenth of the wait as jitter.
+		client: &http.Client{Transport: transport, Timeout: consulWait + consulWait/16 + sourceTimeout},
+	}, nil
+}
+
+// Fetch implements ConfigSource.
+func (c *ConsulSource) Fetch(ctx context.Context) ([]byte, error) {
+	data, index, err := c.get(ctx, url.Values{"raw": {""}})
+	if err != nil {
+		return nil, err
+	}
+	c.setIndex(index)
+	return data, nil
+}
+
+// Watch implements ConfigWatcher using Consul blocking queries.
+func (c *ConsulSource) Watch(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		last := c.index
+		c.mu.Unlock()
+
+		query := url.Values{"index": {strconv.FormatUint(last, 10)}, "wait": {consulWait.String()}}
+		_, index, err := c.get(ctx, query)
+		if err != nil {
+			return err
+		}
+		if index != last {
+			c.setIndex(index)
+			return nil
+		}
+	}
+}
+
+func (c *ConsulSource) setIndex(index uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = index
+}
+
+// get performs a KV request and returns the body and X-Consul-Index.
+func (c *ConsulSource) get(ctx context.Context, query url.Values) ([]byte, uint64, error) {
+	u := url.URL{Scheme: c.scheme, Host: c.addr, Path: "/v1/kv/" + c.key, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// A missing key still returns an index to wait on.
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && query.Has("index"):
+		return nil, index, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, 0, fmt.Errorf("consul: GET %s: %s", c.key, resp.Status)
+	}
+	return body, index, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+)
+
+// DebugAuth returns a wrapper protecting the debug handlers with the
+// "user:password" basic auth credentials in authFile.
+func DebugAuth(authFile string) (func(http.Handler) http.Handler, error) {
+	if authFile == "" {
+		return nil, fmt.Errorf("-debug-pprof and -debug-scripts require -debug-auth-file")
+	}
+	data, err := os.ReadFile(authFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read debug credentials: %w", err)
+	}
+	user, password, ok := strings.Cut(strings.TrimSpace(string(data)), ":")
+	if !ok || user == "" || password == "" {
+		return nil, fmt.Errorf("%s must contain user:password", authFile)
+	}
+	return func(handler http.Handler) http.Handler {
+		return basicAuth(user, password, handler)
+	}, nil
+}
+
+// HandleDebug registers the pprof handlers under /debug/pprof/ on mux,
+// protected by auth.
+func HandleDebug(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	mux.Handle("/debug/pprof/", auth(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", auth(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", auth(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", auth(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", auth(http.HandlerFunc(pprof.Trace)))
+}
+
+// basicAuth only passes requests carrying the given credentials on to
+// handler.
+func basicAuth(user, password string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="custom_exporter debug"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
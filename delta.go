@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// observation is the value of a series at a point in time.
+type observation struct {
+	value float64
+	time  time.Time
+}
+
+// addDeltas appends the increase since the previous run of the metrics
+// listed in Deltas and Rates. Values observed without a timestamp are taken
+// to be observed now.
+func (s *Script) addDeltas(metrics []Metric, now time.Time) []Metric {
+	if len(s.Config.Deltas) == 0 && len(s.Config.Rates) == 0 {
+		return metrics
+	}
+
+	observed := make(map[string]observation)
+	for _, metric := range metrics {
+		key := metric.Name
+		if key == "" {
+			key = metric.MonType
+		}
+		delta, rate := slices.Contains(s.Config.Deltas, key), slices.Contains(s.Config.Rates, key)
+		if !delta && !rate {
+			continue
+		}
+
+		current := observation{metric.Value, metric.Timestamp}
+		if current.time.IsZero() {
+			current.time = now
+		}
+		series := seriesKey(metric.FullName(), metric.AllLabels())
+		observed[series] = current
+
+		last, ok := s.observed[series]
+		if !ok || !current.time.After(last.time) {
+			continue
+		}
+		increase := current.value - last.value
+		if increase < 0 {
+			increase = current.value // The total was reset.
+		}
+		if delta {
+			metrics = append(metrics, suffixed(metric, "_delta", increase))
+		}
+		if rate {
+			metrics = append(metrics, suffixed(metric, "_rate", increase/current.time.Sub(last.time).Seconds()))
+		}
+	}
+	s.observed = observed
+	return metrics
+}
+
+// suffixed returns a copy of metric with value, named with suffix, or with
+// suffix appended to mon_type for lines without a name.
+func suffixed(metric Metric, suffix string, value float64) Metric {
+	if metric.Name == "" {
+		metric.MonType += suffix
+	} else {
+		metric.Name += suffix
+	}
+	metric.Value = value
+	return metric
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAddDeltas(t *testing.T) {
+	s := &Script{Config: ScriptConfig{Deltas: []string{"requests", "errors"}, Rates: []string{"requests"}}}
+	start := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name    string
+		elapsed time.Duration
+		value   float64
+		want    []string
+	}{
+		{"first run", 0, 100, nil},
+		{"increase", 10 * time.Second, 150, []string{`requests_delta{} 50`, `requests_rate{} 5`}},
+		{"no time passed", 10 * time.Second, 170, nil},
+		{"reset", 30 * time.Second, 40, []string{`requests_delta{} 40`, `requests_rate{} 2`}},
+	} {
+		metrics := s.addDeltas([]Metric{{Name: "requests", Value: tc.value}, {Name: "other", Value: 1}}, start.Add(tc.elapsed))
+		var got []string
+		for _, metric := range metrics[2:] {
+			got = append(got, formatMetric(metric))
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// Lines without a name are matched by mon_type.
+	s = &Script{Config: ScriptConfig{Deltas: []string{"errors"}}}
+	s.addDeltas([]Metric{{MonType: "errors", Value: 3}}, start)
+	metrics := s.addDeltas([]Metric{{MonType: "errors", Value: 5}}, start.Add(time.Minute))
+	if len(metrics) != 2 || metrics[1].MonType != "errors_delta" || metrics[1].Value != 2 {
+		t.Errorf("got %+v, want an errors_delta of 2", metrics)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// checkDependencies checks that the DependsOn of every script names other
+// scripts, which are not probes, and that no script depends on itself,
+// even indirectly.
+func checkDependencies(scripts []ScriptConfig) []error {
+	byName := make(map[string]*ScriptConfig, len(scripts))
+	for i := range scripts {
+		byName[scripts[i].Name] = &scripts[i]
+	}
+
+	var errs []error
+	for _, sc := range scripts {
+		for _, dep := range sc.DependsOn {
+			if other, ok := byName[dep]; !ok {
+				errs = append(errs, fmt.Errorf("%s: script %q: depends_on: unknown script %q", sc.Position, sc.Name, dep))
+			} else if other.Probe {
+				errs = append(errs, fmt.Errorf("%s: script %q: depends_on: script %q is a probe", sc.Position, sc.Name, dep))
+			}
+		}
+	}
+
+	// Depth-first search for cycles: a script still on the path is visited
+	// again only through a cycle.
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int, len(scripts))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case onPath:
+			return fmt.Errorf("%s: script %q: dependency cycle %v", byName[name].Position, name, append(path, name))
+		case done:
+			return nil
+		}
+		state[name] = onPath
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				continue
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for _, sc := range scripts {
+		if err := visit(sc.Name, nil); err != nil {
+			return append(errs, err)
+		}
+	}
+	return errs
+}
+
+// setDependencies sets the scripts whose due runs are waited for before
+// every run.
+func (s *Script) setDependencies(deps []*Script) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.deps = deps
+}
+
+// scheduled records when the next run is due and signals the end of the
+// previous one to dependent scripts.
+func (s *Script) scheduled(due time.Time) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.done != nil {
+		close(s.done)
+	}
+	s.due, s.done = due, make(chan struct{})
+}
+
+// waitForDependencies waits for the runs of the dependencies that are due
+// or in progress, so that dependent scripts run after them within a cycle.
+// Dependencies running later are not waited for.
+func (s *Script) waitForDependencies(ctx context.Context) error {
+	s.runMu.Lock()
+	deps := s.deps
+	s.runMu.Unlock()
+
+	now := time.Now()
+	for _, dep := range deps {
+		var stopped <-chan struct{}
+		dep.runMu.Lock()
+		due, done := dep.due, dep.done
+		if dep.ctx != nil {
+			stopped = dep.ctx.Done()
+		}
+		dep.runMu.Unlock()
+		if done == nil || due.After(now) {
+			continue
+		}
+		select {
+		case <-done:
+		case <-stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DerivedMetric is a metric computed from the parsed ones, e.g.
+//
+//	name: disk_used_ratio
+//	expr: used / total
+//
+// Identifiers in Expr refer to metrics by name or, for lines without a
+// name, by mon_type. The expression supports numbers, + - * / and
+// parentheses. It is evaluated for every set of labels, apart from
+// mon_type, for which all identifiers have a value.
+type DerivedMetric struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+
+	expr *exprNode
+}
+
+// exprNode is a node of a compiled expression: a number, an identifier, a
+// negation of left or an operator (+ - * /) applied to left and right.
+type exprNode struct {
+	op         byte // 0 for numbers, 'i' for identifiers, 'n' for negation
+	number     float64
+	identifier string
+	left       *exprNode
+	right      *exprNode
+}
+
+// eval evaluates the expression and reports whether all identifiers have a
+// value.
+func (n *exprNode) eval(values map[string]float64) (float64, bool) {
+	switch n.op {
+	case 0:
+		return n.number, true
+	case 'i':
+		v, ok := values[n.identifier]
+		return v, ok
+	case 'n':
+		v, ok := n.left.eval(values)
+		return -v, ok
+	}
+
+	l, ok := n.left.eval(values)
+	if !ok {
+		return 0, false
+	}
+	r, ok := n.right.eval(values)
+	if !ok {
+		return 0, false
+	}
+	switch n.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	}
+	return l / r, true
+}
+
+// compile checks the name of the derived metric and compiles its
+// expression.
+func (d *DerivedMetric) compile() error {
+	if !metricNameRE.MatchString(d.Name) {
+		return fmt.Errorf("derived metric: invalid metric name %q", d.Name)
+	}
+	p := &exprParser{input: d.Expr}
+	expr, err := p.parse()
+	if err != nil {
+		return fmt.Errorf("derived metric %s: %w", d.Name, err)
+	}
+	d.expr = expr
+	return nil
+}
+
+// Derive appends the derived metrics computed from metrics.
+func Derive(metrics []Metric, derived []DerivedMetric) []Metric {
+	if len(derived) == 0 {
+		return metrics
+	}
+
+	var order []string
+	groups := make(map[string]map[string]float64)
+	labels := make(map[string]map[string]string)
+	for _, metric := range metrics {
+		identifier := metric.Name
+		groupLabels := make(map[string]string)
+		for name, value := range metric.AllLabels() {
+			if value != "" {
+				groupLabels[name] = value
+			}
+		}
+		if identifier == "" {
+			identifier = metric.MonType
+			delete(groupLabels, "mon_type")
+		}
+
+		key := seriesKey("", groupLabels)
+		if groups[key] == nil {
+			groups[key] = make(map[string]float64)
+			labels[key] = groupLabels
+			order = append(order, key)
+		}
+		groups[key][identifier] = metric.Value
+	}
+
+	for _, d := range derived {
+		for _, key := range order {
+			if value, ok := d.expr.eval(groups[key]); ok {
+				metrics = append(metrics, Metric{Name: d.Name, Labels: labels[key], Value: value})
+			}
+		}
+	}
+	return metrics
+}
+
+// exprParser is a recursive descent parser for the arithmetic expressions
+// of derived metrics.
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) parse() (*exprNode, error) {
+	expr, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos:], p.pos)
+	}
+	return expr, nil
+}
+
+// sum parses terms separated by + and -.
+func (p *exprParser) sum() (*exprNode, error) {
+	left, err := p.term()
+	for err == nil {
+		op := p.operator("+-")
+		if op == 0 {
+			return left, nil
+		}
+		var right *exprNode
+		if right, err = p.term(); err == nil {
+			left = &exprNode{op: op, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+// term parses factors separated by * and /.
+func (p *exprParser) term() (*exprNode, error) {
+	left, err := p.factor()
+	for err == nil {
+		op := p.operator("*/")
+		if op == 0 {
+			return left, nil
+		}
+		var right *exprNode
+		if right, err = p.factor(); err == nil {
+			left = &exprNode{op: op, left: left, right: right}
+		}
+	}
+	return nil, err
+}
+
+// factor parses a number, an identifier, a negation or a parenthesized
+// expression.
+func (p *exprParser) factor() (*exprNode, error) {
+	p.skipSpace()
+	if p.pos == len(p.input) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch c := rune(p.input[p.pos]); {
+	case c == '-':
+		p.pos++
+		operand, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{op: 'n', left: operand}, nil
+
+	case c == '(':
+		p.pos++
+		expr, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.operator(")") == 0 {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		return expr, nil
+
+	case c == '.' || unicode.IsDigit(c):
+		start := p.pos
+		for p.pos < len(p.input) && strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 {
+			p.pos++
+			// The sign of an exponent belongs to the number, as in 1e-3.
+			if exponent := p.input[p.pos-1]; (exponent == 'e' || exponent == 'E') && p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
+				p.pos++
+			}
+		}
+		number, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+		}
+		return &exprNode{number: number}, nil
+
+	case c == '_' || c == ':' || unicode.IsLetter(c):
+		start := p.pos
+		for p.pos < len(p.input) && metricNameRE.MatchString(p.input[start:p.pos+1]) {
+			p.pos++
+		}
+		return &exprNode{op: 'i', identifier: p.input[start:p.pos]}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos:], p.pos)
+}
+
+// operator consumes and returns the next character if it is one of ops.
+func (p *exprParser) operator(ops string) byte {
+	p.skipSpace()
+	if p.pos < len(p.input) && strings.IndexByte(ops, p.input[p.pos]) >= 0 {
+		p.pos++
+		return p.input[p.pos-1]
+	}
+	return 0
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
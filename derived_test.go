@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestDerivedNumbers(t *testing.T) {
+	for expr, want := range map[string]float64{
+		"x * 1e-3":      2,
+		"x * 1E+3":      2e6,
+		"x * 2.5e2":     5e5,
+		"x*1e-3-1":      1,
+		"(x - 1e3) / 2": 500,
+	} {
+		derived := []DerivedMetric{{Name: "y", Expr: expr}}
+		if err := derived[0].compile(); err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		metrics := Derive([]Metric{{Name: "x", Value: 2000}}, derived)
+		if len(metrics) != 2 || metrics[1].Name != "y" || metrics[1].Value != want {
+			t.Errorf("%s: got %+v, want y = %v", expr, metrics, want)
+		}
+	}
+	for _, expr := range []string{"x * 1e", "x * 1e-", "x * 1.2.3"} {
+		derived := DerivedMetric{Name: "y", Expr: expr}
+		if err := derived.compile(); err == nil {
+			t.Errorf("%s: compiled, want an error", expr)
+		}
+	}
+}
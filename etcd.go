@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// EtcdSource reads the configuration from an etcd v3 key through the JSON
+// gRPC gateway and watches it for changes.
+type EtcdSource struct {
+	scheme string
+	addr   string
+	key    string
+	client *http.Client
+
+	mu       sync.Mutex
+	revision int64
+}
+
+// NewEtcdSource returns a source for key on the etcd endpoint at addr,
+// connecting as described by the query parameters of the config URL.
+func NewEtcdSource(addr, key string, query url.Values) (*EtcdSource, error) {
+	if addr == "" {
+		addr = "127.0.0.1:2379"
+	}
+	scheme, transport, err := sourceTransport(query)
+	if err != nil {
+		return nil, fmt.Errorf("etcd: %w", err)
+	}
+	// Watch responses stream until the key changes, so only the wait for
+	// the response headers is bounded.
+	transport.ResponseHeaderTimeout = sourceTimeout
+	return &EtcdSource{scheme: scheme, addr: addr, key: key, client: &http.Client{Transport: transport}}, nil
+}
+
+// Fetch implements ConfigSource.
+func (e *EtcdSource) Fetch(ctx context.Context) ([]byte, error) {
+	var resp struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := e.post(ctx, "/v3/kv/range", map[string]any{"key": []byte(e.key)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd: key %s not found", e.key)
+	}
+
+	revision, _ := strconv.ParseInt(resp.Header.Revision, 10, 64)
+	e.mu.Lock()
+	e.revision = revision
+	e.mu.Unlock()
+	return resp.Kvs[0].Value, nil
+}
+
+// Watch implements ConfigWatcher. It streams watch events for the key
+// starting after the revision last fetched.
+func (e *EtcdSource) Watch(ctx context.Context) error {
+	e.mu.Lock()
+	start := e.revision + 1
+	e.mu.Unlock()
+
+	body, err := json.Marshal(map[string]any{
+		"create_request": map[string]any{
+			"key":            []byte(e.key),
+			"start_revision": strconv.FormatInt(start, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.request(ctx, "/v3/watch", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Header struct {
+					Revision string `json:"revision"`
+				} `json:"header"`
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			return fmt.Errorf("etcd: watch: %w", err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("etcd: watch: %s", msg.Error.Message)
+		}
+		if len(msg.Result.Events) > 0 {
+			return nil
+		}
+	}
+}
+
+// post sends a JSON request to the gateway and decodes the JSON response.
+func (e *EtcdSource) post(ctx context.Context, path string, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := e.request(ctx, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("etcd: %s: %w", path, err)
+	}
+	return nil
+}
+
+func (e *EtcdSource) request(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	u := url.URL{Scheme: e.scheme, Host: e.addr, Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("etcd: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd: POST %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
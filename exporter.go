@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "custom_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "custom_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+	parseErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_parse_errors_total",
+		Help: "Number of malformed lines in script output.",
+	}, []string{"script"})
+	invalidValuesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_invalid_values_total",
+		Help: "Number of empty, non-numeric, NaN or infinite values handled by on_invalid_value.",
+	}, []string{"script"})
+	counterResetsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_counter_resets_total",
+		Help: "Number of times a counter reported by a script decreased.",
+	}, []string{"script"})
+	droppedSeriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_dropped_series_total",
+		Help: "Number of series dropped for exceeding max_series.",
+	}, []string{"script"})
+	skippedRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_skipped_runs_total",
+		Help: "Number of runs skipped because the previous run was still going on.",
+	}, []string{"script"})
+	backoffSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_backoff_seconds",
+		Help: "Delay before retrying a failing script, 0 after a successful run.",
+	}, []string{"script"})
+	scriptQuarantined = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_script_quarantined",
+		Help: "Whether a script is quarantined after failing quarantine_after times in a row.",
+	}, []string{"script"})
+	scriptDisabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_script_disabled",
+		Help: "Whether a script was disabled through the admin API.",
+	}, []string{"script"})
+	scriptDataStale = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_script_data_stale",
+		Help: "Whether the last run of a script with on_failure: stale failed and older values are exported.",
+	}, []string{"script"})
+	scriptDataAge = &dataAges{
+		desc: prometheus.NewDesc("custom_exporter_script_data_age_seconds",
+			"Time since the last successful run of a script with on_failure: stale.", []string{"script"}, nil),
+		updated: make(map[string]time.Time),
+	}
+	memoryLimitedRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_memory_limited_runs_total",
+		Help: "Number of runs rejected because their parsed output exceeded -max-memory.",
+	}, []string{"script"})
+	truncatedLinesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_truncated_lines_total",
+		Help: "Number of output lines ignored for exceeding max_lines.",
+	}, []string{"script"})
+	duplicateSeriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_duplicate_series_total",
+		Help: "Number of series printed more than once in a run, handled by on_duplicate.",
+	}, []string{"script"})
+	scriptDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "custom_exporter_script_duration_seconds",
+		Help:    "Time scripts took to run, including parsing their output.",
+		Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 60, 300},
+	}, []string{"script"})
+	scriptExitCode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_script_exit_code",
+		Help: "Exit status of the last run of a script, -1 if it was killed by a signal.",
+	}, []string{"script"})
+	scriptLastSuccessSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_script_last_success_timestamp_seconds",
+		Help: "Timestamp of the last successful run of a script.",
+	}, []string{"script"})
+	scriptUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "custom_exporter_script_up",
+		Help: "Whether the last run of a script succeeded.",
+	}, []string{"script"})
+	scriptRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "custom_exporter_script_runs_total",
+		Help: "Number of runs of a script by result, success or failure.",
+	}, []string{"script", "result"})
+	queuedRuns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "custom_exporter_queued_runs",
+		Help: "Number of runs waiting for max_concurrent_runs.",
+	})
+	runQueueWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "custom_exporter_run_queue_wait_seconds",
+		Help:    "Time runs waited for max_concurrent_runs.",
+		Buckets: []float64{0.01, 0.1, 1, 5, 15, 60, 300},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configReloadSuccess, configReloadSeconds, parseErrorsTotal, invalidValuesTotal, counterResetsTotal, droppedSeriesTotal, truncatedLinesTotal, duplicateSeriesTotal, skippedRunsTotal, backoffSeconds, scriptQuarantined, scriptDisabled)
+	prometheus.MustRegister(queuedRuns, runQueueWaitSeconds, scriptDataStale, scriptDataAge, memoryLimitedRunsTotal)
+	prometheus.MustRegister(scriptDurationSeconds, scriptExitCode, scriptLastSuccessSeconds, scriptRunsTotal, scriptUp)
+}
+
+// Values of ScriptConfig.OnDuplicate.
+const (
+	DuplicateLast  = "last"
+	DuplicateFirst = "first"
+	DuplicateSum   = "sum"
+	DuplicateFail  = "fail"
+)
+
+// Values of ScriptConfig.OnFailure.
+const (
+	FailureKeep  = "keep"
+	FailureStale = "stale"
+)
+
+// dataAges exports the age of the values of each script, as of scrape time.
+type dataAges struct {
+	desc *prometheus.Desc
+
+	mu      sync.Mutex
+	updated map[string]time.Time
+}
+
+// Describe implements prometheus.Collector.
+func (a *dataAges) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+// Collect implements prometheus.Collector.
+func (a *dataAges) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for script, updated := range a.updated {
+		ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, now.Sub(updated).Seconds(), script)
+	}
+}
+
+// Set records that the values of script were updated at t.
+func (a *dataAges) Set(script string, t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.updated[script] = t
+}
+
+// Delete stops exporting the age of the values of script.
+func (a *dataAges) Delete(script string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.updated, script)
+}
+
+// Script is a configured script together with the metrics it exports.
+type Script struct {
+	Config ScriptConfig
+
+	mu          sync.RWMutex
+	gauges      *GaugeSet
+	passthrough []*dto.MetricFamily
+	natives     *nativeHistograms
+
+	// series is the number of series exported by the last successful run;
+	// last reports on the last run, successful or not. output holds the
+	// output of the last run if captureOutput is set.
+	series        int
+	last          RunReport
+	output        *ScriptOutput
+	captureOutput bool
+
+	// counters holds the last value of each counter to detect resets.
+	counters map[string]float64
+
+	// observed holds the last observation of each series of Deltas and
+	// Rates.
+	observed map[string]observation
+
+	// retained holds the series kept by ExpireAfter.
+	retained map[string]Metric
+
+	// runMu guards the state of runs. For runs on scrape, lastRun is when
+	// the last one started and running is closed when the current one
+	// ends. For scheduled runs, due is when the next one starts and done
+	// is closed when it ends. deps are the scripts of DependsOn.
+	runMu   sync.Mutex
+	lastRun time.Time
+	running chan struct{}
+	due     time.Time
+	done    chan struct{}
+	deps    []*Script
+
+	// limiter, if set, limits the runs of all scripts.
+	limiter *RunLimiter
+
+	// collected is set once metrics were collected successfully.
+	collected atomic.Bool
+
+	// maxMemory, if set, rejects runs whose parsed output exceeds it.
+	maxMemory int64
+
+	// quarantined is set while the script is quarantined; Enable wakes it
+	// up through resume. Trigger wakes it up through trigger.
+	quarantined atomic.Bool
+	resume      chan struct{}
+	trigger     chan struct{}
+
+	// disabled is set while collection is stopped by Disable. loop is
+	// closed when the goroutine of periodic collection ends; stopped is
+	// set once the script was removed by Stop. Both are guarded by runMu.
+	disabled atomic.Bool
+	loop     chan struct{}
+	stopped  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewScript creates the registry holding the gauges for a single script.
+// Each script gets its own registry so that scripts with different static
+// labels can export the same metric name side by side.
+func NewScript(sc ScriptConfig) (*Script, error) {
+	if sc.Format != FormatPrometheus {
+		if _, err := NewParser(sc); err != nil {
+			return nil, fmt.Errorf("script %q: %w", sc.Name, err)
+		}
+	}
+
+	gauges, err := NewGaugeSet(sc, nil)
+	if err != nil {
+		return nil, fmt.Errorf("script %q: %w", sc.Name, err)
+	}
+	script := &Script{
+		Config:  sc,
+		gauges:  gauges,
+		resume:  make(chan struct{}, 1),
+		trigger: make(chan struct{}, 1),
+	}
+	if len(sc.NativeHistograms) > 0 {
+		script.natives = newNativeHistograms(sc)
+	}
+	if !sc.OnScrape && !sc.Probe && sc.Schedule == "" {
+		script.scheduled(time.Now()) // The first run is due right away.
+	}
+	return script, nil
+}
+
+// RunReport describes a run of a script.
+type RunReport struct {
+	Start    time.Time
+	Duration time.Duration
+	// ExitCode is the exit status of a failed script, -1 if it did not
+	// start or was killed by a signal, and 0 otherwise.
+	ExitCode int
+	Err      error
+}
+
+// Run executes the script once and updates the exported metrics. With
+// OnFailure "stale" it also records whether the exported values are stale.
+func (s *Script) Run(ctx context.Context) error {
+	start := time.Now()
+	var output *ScriptOutput
+	if s.captureOutput && s.Config.Format != FormatCollectd {
+		output = &ScriptOutput{Start: start}
+		ctx = withOutput(ctx, output)
+	}
+	err := s.run(ctx)
+	report := RunReport{Start: start, Duration: time.Since(start), Err: err}
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		report.ExitCode = cmdErr.ExitCode()
+	}
+	s.mu.Lock()
+	s.last = report
+	if output != nil {
+		output.finish(err)
+		s.output = output
+	}
+	s.mu.Unlock()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		s.observe(report)
+	}
+
+	if s.Config.OnFailure == FailureStale && ctx.Err() == nil {
+		if err != nil {
+			scriptDataStale.WithLabelValues(s.Config.Name).Set(1)
+		} else {
+			scriptDataStale.WithLabelValues(s.Config.Name).Set(0)
+			scriptDataAge.Set(s.Config.Name, time.Now())
+		}
+	}
+	return err
+}
+
+// observe updates the metrics about runs of the script with report.
+func (s *Script) observe(report RunReport) {
+	name := s.Config.Name
+	scriptDurationSeconds.WithLabelValues(name).Observe(report.Duration.Seconds())
+	scriptExitCode.WithLabelValues(name).Set(float64(report.ExitCode))
+	if report.Err != nil {
+		scriptUp.WithLabelValues(name).Set(0)
+		scriptRunsTotal.WithLabelValues(name, "failure").Inc()
+		return
+	}
+	scriptUp.WithLabelValues(name).Set(1)
+	scriptRunsTotal.WithLabelValues(name, "success").Inc()
+	scriptLastSuccessSeconds.WithLabelValues(name).Set(float64(report.Start.Add(report.Duration).UnixNano()) / 1e9)
+}
+
+func (s *Script) run(ctx context.Context) error {
+	if err := s.waitForDependencies(ctx); err != nil {
+		return err
+	}
+	if s.Config.Format == FormatCollectd {
+		return s.runCollectd(ctx) // Not limited, as it usually runs forever.
+	}
+	if s.limiter != nil {
+		if err := s.limiter.Acquire(ctx); err != nil {
+			return err
+		}
+		defer s.limiter.Release()
+	}
+	if s.Config.Format == FormatPrometheus {
+		families, err := ExecutePassthrough(ctx, s.Config)
+		if err != nil {
+			return err
+		}
+		series := 0
+		for _, family := range families {
+			series += len(family.Metric)
+		}
+		s.mu.Lock()
+		s.passthrough, s.series = families, series
+		s.mu.Unlock()
+		s.collected.Store(true)
+		return nil
+	}
+
+	metrics, err := ExecuteCommand(ctx, s.Config)
+	if output := outputFrom(ctx); output != nil {
+		output.setParsed(metrics, err)
+	}
+	var malformed ParseErrors
+	if errors.As(err, &malformed) {
+		parseErrorsTotal.WithLabelValues(s.Config.Name).Add(float64(len(malformed)))
+		if s.Config.OnParseError == ParseErrorSkip {
+			log.Printf("Skipped malformed output of %s: %v", s.Config.Name, err)
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if s.maxMemory > 0 {
+		if size := metricsSize(metrics); size > s.maxMemory {
+			memoryLimitedRunsTotal.WithLabelValues(s.Config.Name).Inc()
+			return fmt.Errorf("parsed output of about %d bytes exceeds -max-memory", size)
+		}
+	}
+	return s.SetMetrics(metrics)
+}
+
+// Gather implements prometheus.Gatherer. Scripts with OnScrape are run
+// first.
+func (s *Script) Gather() ([]*dto.MetricFamily, error) {
+	if s.Config.OnScrape {
+		s.runOnScrape()
+	}
+
+	s.mu.RLock()
+	gauges, passthrough := s.gauges, s.passthrough
+	s.mu.RUnlock()
+
+	families, err := gauges.Registry.Gather()
+	if s.natives != nil {
+		natives, nativeErr := s.natives.Gather()
+		families, err = append(families, natives...), errors.Join(err, nativeErr)
+	}
+	return append(families, passthrough...), err
+}
+
+// SetMetrics relabels metrics and replaces the exported values with them.
+// As long as every metric name keeps its label names the gauges are reused;
+// otherwise a new set of gauges is built and swapped in. SetMetrics must not
+// be called concurrently.
+func (s *Script) SetMetrics(metrics []Metric) error {
+	metrics, err := Relabel(metrics, s.Config.RelabelConfigs)
+	if err != nil {
+		return err
+	}
+	if metrics, err = ExpandStateSets(metrics, s.Config.StateSets); err != nil {
+		return err
+	}
+	TransformValues(metrics, s.Config.Transforms)
+	if s.natives != nil {
+		metrics = s.natives.Observe(metrics, time.Now())
+	}
+	metrics = Derive(metrics, s.Config.Derived)
+	metrics = Aggregate(metrics, s.Config.Aggregations)
+	metrics = s.addDeltas(metrics, time.Now())
+	s.setTypes(metrics)
+	metrics = FilterLabels(metrics, s.Config.KeepLabels, s.Config.DropLabels)
+	AddLabels(metrics, s.Config.Labels)
+	metrics = s.retain(metrics, time.Now())
+	metrics = s.limitSeries(metrics)
+	if metrics, err = s.mergeDuplicates(metrics); err != nil {
+		return err
+	}
+
+	gauges := s.gauges
+	schema := MetricSchema(metrics)
+	if !gauges.Matches(schema) {
+		if gauges, err = NewGaugeSet(s.Config, schema); err != nil {
+			return err
+		}
+	}
+
+	if err := gauges.Set(metrics); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.gauges, s.series = gauges, len(metrics)
+	s.mu.Unlock()
+	s.collected.Store(true)
+	return nil
+}
+
+// setTypes sets the type of each metric as configured and counts counters
+// whose value decreased since the last run.
+func (s *Script) setTypes(metrics []Metric) {
+	counters := make(map[string]float64)
+	for i := range metrics {
+		s.Config.SetType(&metrics[i])
+		if metrics[i].Type != MetricTypeCounter {
+			continue
+		}
+		key := seriesKey(metrics[i].FullName(), metrics[i].AllLabels())
+		if last, ok := s.counters[key]; ok && metrics[i].Value < last {
+			counterResetsTotal.WithLabelValues(s.Config.Name).Inc()
+			log.Printf("Counter %s of %s was reset from %v to %v", metrics[i].FullName(), s.Config.Name, last, metrics[i].Value)
+		}
+		counters[key] = metrics[i].Value
+	}
+	s.counters = counters
+}
+
+// retain adds the series reported within ExpireAfter but missing from
+// metrics.
+func (s *Script) retain(metrics []Metric, now time.Time) []Metric {
+	expireAfter := time.Duration(s.Config.ExpireAfter)
+	if expireAfter <= 0 {
+		return metrics
+	}
+
+	retained := make(map[string]Metric, len(metrics))
+	for _, metric := range metrics {
+		metric.seen = now
+		retained[metricKey(metric)] = metric
+	}
+	for key, metric := range s.retained {
+		if _, ok := retained[key]; !ok && now.Sub(metric.seen) < expireAfter {
+			retained[key] = metric
+			metrics = append(metrics, metric)
+		}
+	}
+	s.retained = retained
+	return metrics
+}
+
+// limitSeries drops the series beyond MaxSeries, keeping those that come
+// first in order of their names and labels. The buckets or quantiles, sum
+// and count of a histogram or summary count as a single series. Native
+// histograms count against MaxSeries first.
+func (s *Script) limitSeries(metrics []Metric) []Metric {
+	if s.Config.MaxSeries <= 0 {
+		return metrics
+	}
+	limit := s.Config.MaxSeries
+	if s.natives != nil {
+		limit = max(limit-s.natives.Len(), 0)
+	}
+
+	keys := make([]string, len(metrics))
+	unique := make(map[string]bool, len(metrics))
+	for i, metric := range metrics {
+		if metric.Type == MetricTypeHistogram || metric.Type == MetricTypeSummary {
+			keys[i] = seriesKey(metric.FullName(), compositeLabels(metric))
+		} else {
+			keys[i] = seriesKey(metric.FullName(), metric.AllLabels())
+		}
+		unique[keys[i]] = true
+	}
+	if len(unique) <= limit {
+		return metrics
+	}
+
+	sorted := slices.Sorted(maps.Keys(unique))
+	kept := make(map[string]bool, limit)
+	for _, key := range sorted[:limit] {
+		kept[key] = true
+	}
+	limited := make([]Metric, 0, limit)
+	for i, metric := range metrics {
+		if kept[keys[i]] {
+			limited = append(limited, metric)
+		}
+	}
+
+	dropped := len(sorted) - limit
+	droppedSeriesTotal.WithLabelValues(s.Config.Name).Add(float64(dropped))
+	log.Printf("Dropped %d series of %s exceeding max_series %d", dropped, s.Config.Name, s.Config.MaxSeries)
+	return limited
+}
+
+// mergeDuplicates counts the series reported more than once and keeps a
+// single metric for each, as set by OnDuplicate.
+func (s *Script) mergeDuplicates(metrics []Metric) ([]Metric, error) {
+	index := make(map[string]int, len(metrics))
+	merged := metrics[:0]
+	duplicates := 0
+	for _, metric := range metrics {
+		key := metricKey(metric)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, metric)
+			continue
+		}
+
+		duplicates++
+		switch s.Config.OnDuplicate {
+		case DuplicateFirst:
+		case DuplicateSum:
+			merged[i].Value += metric.Value
+		case DuplicateFail:
+			duplicateSeriesTotal.WithLabelValues(s.Config.Name).Add(float64(duplicates))
+			return nil, fmt.Errorf("series %s with labels %v reported more than once", metric.FullName(), metric.AllLabels())
+		default:
+			merged[i] = metric
+		}
+	}
+	if duplicates > 0 {
+		duplicateSeriesTotal.WithLabelValues(s.Config.Name).Add(float64(duplicates))
+	}
+	return merged, nil
+}
+
+// Start begins periodic collection in a new goroutine. Scripts run on
+// scrape or probe only get ready to run.
+func (s *Script) Start() {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	s.start()
+}
+
+// start is Start with runMu held.
+func (s *Script) start() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if !s.Config.OnScrape && !s.Config.Probe {
+		loop := make(chan struct{})
+		s.loop = loop
+		go func(ctx context.Context) {
+			defer close(loop)
+			UpdateMetrics(ctx, s)
+		}(s.ctx)
+	}
+}
+
+// runOnScrape runs the script of a scrape unless the last run is recent
+// enough to be served from the cache. Concurrent scrapes wait for the same
+// run. Failures are logged and leave the previous values in place.
+func (s *Script) runOnScrape() {
+	s.runMu.Lock()
+	if running := s.running; running != nil {
+		s.runMu.Unlock()
+		<-running
+		return
+	}
+	if s.ctx == nil || s.disabled.Load() || time.Since(s.lastRun) < time.Duration(s.Config.CacheFor) {
+		s.runMu.Unlock()
+		return
+	}
+	running := make(chan struct{})
+	s.running, s.lastRun = running, time.Now()
+	parent := s.ctx
+	s.runMu.Unlock()
+
+	defer func() {
+		s.runMu.Lock()
+		s.running = nil
+		s.runMu.Unlock()
+		close(running)
+	}()
+
+	ctx, cancel := context.WithTimeout(parent, time.Duration(s.Config.Interval))
+	defer cancel()
+	if err := s.Run(ctx); err != nil && parent.Err() == nil {
+		log.Printf("Error executing command %s: %v", s.Config.Name, err)
+	}
+}
+
+// Enable ends the quarantine of the script and runs it right away, or
+// restarts collection of a disabled script as if it was just loaded. It
+// reports whether the script was quarantined or disabled.
+func (s *Script) Enable() bool {
+	if s.disabled.Load() {
+		return s.restart()
+	}
+	if !s.quarantined.Load() {
+		return false
+	}
+	select {
+	case s.resume <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// restart starts collection of a disabled script again, once the
+// collection stopped by Disable has ended.
+func (s *Script) restart() bool {
+	s.runMu.Lock()
+	loop := s.loop
+	s.runMu.Unlock()
+	if loop != nil {
+		<-loop
+	}
+
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.stopped || !s.disabled.Swap(false) {
+		return false
+	}
+	s.quarantined.Store(false)
+	scriptDisabled.WithLabelValues(s.Config.Name).Set(0)
+	s.start()
+	return true
+}
+
+// Disable stops collection until the script is enabled again, killing it
+// if it is running; the values of the last run remain exported. It reports
+// whether the script was enabled.
+func (s *Script) Disable() bool {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.stopped || s.disabled.Swap(true) {
+		return false
+	}
+	scriptDisabled.WithLabelValues(s.Config.Name).Set(1)
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return true
+}
+
+// Trigger runs the script right away instead of waiting for its next run.
+// Scripts run on scrape run before Trigger returns; probe and disabled
+// scripts cannot be triggered.
+func (s *Script) Trigger() error {
+	switch {
+	case s.Config.Probe:
+		return errors.New("probe scripts only run on requests to /probe")
+	case s.disabled.Load():
+		return errors.New("script is disabled")
+	case s.Config.OnScrape:
+		s.runMu.Lock()
+		s.lastRun = time.Time{} // Bypass CacheFor.
+		s.runMu.Unlock()
+		s.runOnScrape()
+		return nil
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stop ends collection and kills the script if it is running.
+func (s *Script) Stop() {
+	s.runMu.Lock()
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.runMu.Unlock()
+	if s.disabled.Load() {
+		scriptDisabled.DeleteLabelValues(s.Config.Name)
+	}
+	scriptDurationSeconds.DeleteLabelValues(s.Config.Name)
+	scriptExitCode.DeleteLabelValues(s.Config.Name)
+	scriptLastSuccessSeconds.DeleteLabelValues(s.Config.Name)
+	scriptUp.DeleteLabelValues(s.Config.Name)
+	backoffSeconds.DeleteLabelValues(s.Config.Name)
+	scriptQuarantined.DeleteLabelValues(s.Config.Name)
+	scriptRunsTotal.DeletePartialMatch(prometheus.Labels{"script": s.Config.Name})
+	skippedRunsTotal.DeletePartialMatch(prometheus.Labels{"script": s.Config.Name})
+	duplicateSeriesTotal.DeleteLabelValues(s.Config.Name)
+	truncatedLinesTotal.DeleteLabelValues(s.Config.Name)
+	memoryLimitedRunsTotal.DeleteLabelValues(s.Config.Name)
+	droppedSeriesTotal.DeleteLabelValues(s.Config.Name)
+	counterResetsTotal.DeleteLabelValues(s.Config.Name)
+	invalidValuesTotal.DeleteLabelValues(s.Config.Name)
+	parseErrorsTotal.DeleteLabelValues(s.Config.Name)
+	if s.Config.OnFailure == FailureStale {
+		scriptDataStale.DeleteLabelValues(s.Config.Name)
+		scriptDataAge.Delete(s.Config.Name)
+	}
+}
+
+// Exporter manages the running scripts and gathers their metrics.
+type Exporter struct {
+	options *Options
+
+	// Graphite, if set, receives the Graphite mappings of every applied
+	// configuration.
+	Graphite *Graphite
+
+	limiter *RunLimiter
+
+	mu      sync.RWMutex
+	scripts map[string]*Script
+	labels  map[string]string
+	users   map[string]string
+	token   string
+	// tokenFile holds the bearer token, read on every request.
+	tokenFile string
+
+	authMu    sync.Mutex
+	authCache map[[sha256.Size]byte]bool
+
+	// quit is closed when a shutdown is requested through /-/quit.
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewExporter returns an Exporter that loads its configuration from opts.
+func NewExporter(opts *Options) *Exporter {
+	return &Exporter{
+		options:   opts,
+		limiter:   NewRunLimiter(),
+		scripts:   make(map[string]*Script),
+		authCache: make(map[[sha256.Size]byte]bool),
+		quit:      make(chan struct{}),
+	}
+}
+
+// Reload reads the configuration again and applies it. On failure the
+// running scripts are left untouched.
+func (e *Exporter) Reload() error {
+	err := e.reload()
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return err
+	}
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	return nil
+}
+
+func (e *Exporter) reload() error {
+	cfg, err := e.options.LoadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Scripts = e.options.Shard.Filter(cfg.Scripts)
+	return e.Apply(cfg)
+}
+
+// Apply starts collection for new or changed scripts and stops it for
+// removed or changed ones. Unchanged scripts keep running undisturbed.
+func (e *Exporter) Apply(cfg *Config) error {
+	if err := e.checkAdminAuth(cfg); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	next := make(map[string]*Script, len(cfg.Scripts))
+	var started []*Script
+	for _, sc := range cfg.Scripts {
+		if old, ok := e.scripts[sc.Name]; ok && old.Config.Equal(sc) {
+			next[sc.Name] = old
+			continue
+		}
+
+		script, err := NewScript(sc)
+		if err != nil {
+			return err
+		}
+		script.limiter = e.limiter
+		script.maxMemory = int64(e.options.MaxMemory)
+		script.captureOutput = e.options.DebugScripts
+		next[sc.Name] = script
+		started = append(started, script)
+	}
+
+	for _, script := range next {
+		deps := make([]*Script, 0, len(script.Config.DependsOn))
+		for _, dep := range script.Config.DependsOn {
+			deps = append(deps, next[dep])
+		}
+		script.setDependencies(deps)
+	}
+
+	for name, old := range e.scripts {
+		if next[name] != old {
+			log.Printf("Stopping collection for %s.", name)
+			old.Stop()
+		}
+	}
+	for _, script := range started {
+		log.Printf("Starting collection for %s.", script.Config.Name)
+		script.Start()
+	}
+
+	e.scripts = next
+	e.labels = cfg.Labels
+	e.users = cfg.BasicAuthUsers
+	e.token, e.tokenFile = cfg.BearerToken, cfg.BearerTokenFile
+	e.limiter.SetLimit(cfg.MaxConcurrentRuns)
+	if e.Graphite != nil {
+		e.Graphite.SetMappings(cfg.GraphiteMappings)
+	}
+	return nil
+}
+
+// checkAdminAuth refuses cfg if it leaves the admin API enabled by
+// -web.enable-admin-api open to anyone: it requires basic auth users or a
+// bearer token in cfg, or client authentication by the server.
+func (e *Exporter) checkAdminAuth(cfg *Config) error {
+	if !e.options.EnableAdminAPI || len(cfg.BasicAuthUsers) > 0 || cfg.BearerToken != "" || cfg.BearerTokenFile != "" {
+		return nil
+	}
+	clientAuth, err := e.options.ClientAuth()
+	if err != nil {
+		return err
+	}
+	if !clientAuth {
+		return fmt.Errorf("-web.enable-admin-api requires basic_auth_users, bearer_token, bearer_token_file or client certificates")
+	}
+	return nil
+}
+
+// Gather implements prometheus.Gatherer by merging the registries of all
+// running scripts. Probe scripts are only gathered by ProbeHandler. As
+// merged families must agree on their help, a metric exported by several
+// scripts gets the help of the first one, by name, setting help, or else
+// of the first one exporting it.
+func (e *Exporter) Gather() ([]*dto.MetricFamily, error) {
+	e.mu.RLock()
+	names := make([]string, 0, len(e.scripts))
+	for name, script := range e.scripts {
+		if !script.Config.Probe {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	scripts := make([]*Script, 0, len(names))
+	for _, name := range names {
+		scripts = append(scripts, e.scripts[name])
+	}
+	e.mu.RUnlock()
+
+	gathered := make([][]*dto.MetricFamily, len(scripts))
+	errs := make([]error, len(scripts))
+	help, fallback := make(map[string]string), make(map[string]string)
+	for i, script := range scripts {
+		gathered[i], errs[i] = script.Gather()
+		for _, family := range gathered[i] {
+			name := family.GetName()
+			if _, ok := help[name]; !ok && script.Config.Help != "" {
+				help[name] = family.GetHelp()
+			}
+			if _, ok := fallback[name]; !ok {
+				fallback[name] = family.GetHelp()
+			}
+		}
+	}
+	maps.Copy(fallback, help)
+
+	gatherers := make(prometheus.Gatherers, len(scripts))
+	for i, families := range gathered {
+		for j, family := range families {
+			// Passthrough families are shared by scrapes, so they are
+			// replaced rather than modified.
+			if text := fallback[family.GetName()]; text != family.GetHelp() {
+				families[j] = &dto.MetricFamily{Name: family.Name, Help: &text, Type: family.Type, Unit: family.Unit, Metric: family.Metric}
+			}
+		}
+		gatherers[i] = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, errs[i] })
+	}
+	return gatherers.Gather()
+}
+
+// ScriptMetricsHandler serves the metrics of the script named by the script
+// path value alone, e.g. on /metrics/<script>, so that scripts can be
+// scraped by separate jobs.
+func (e *Exporter) ScriptMetricsHandler(opts promhttp.HandlerOpts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("script")
+		e.mu.RLock()
+		script, ok := e.scripts[name]
+		e.mu.RUnlock()
+		if !ok || script.Config.Probe {
+			http.Error(w, fmt.Sprintf("unknown script %q", name), http.StatusNotFound)
+			return
+		}
+		promhttp.HandlerFor(script, opts).ServeHTTP(w, r)
+	}
+}
+
+// WithLabels returns a gatherer adding the global labels of the applied
+// configuration to the metrics of g that do not have them yet.
+func (e *Exporter) WithLabels(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+
+		e.mu.RLock()
+		labels := e.labels
+		e.mu.RUnlock()
+
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				missing := maps.Clone(labels)
+				for _, pair := range metric.Label {
+					delete(missing, pair.GetName())
+				}
+				metric.Label = mergeLabelPairs(metric.Label, missing)
+			}
+		}
+		return families, err
+	})
+}
+
+// EnableHandler serves /-/enable, ending the quarantine of the script named
+// by the script parameter on POST, or enabling it again if it is disabled.
+func (e *Exporter) EnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "This endpoint requires a POST or PUT request.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("script")
+	e.mu.RLock()
+	script, ok := e.scripts[name]
+	e.mu.RUnlock()
+	switch {
+	case !ok:
+		http.Error(w, fmt.Sprintf("unknown script %q", name), http.StatusNotFound)
+	case !script.Enable():
+		http.Error(w, fmt.Sprintf("script %q is neither quarantined nor disabled", name), http.StatusConflict)
+	default:
+		log.Printf("Enabling script %s via HTTP...", name)
+		fmt.Fprintln(w, "OK")
+	}
+}
+
+// ReloadHandler serves /-/reload, reloading the configuration on POST.
+func (e *Exporter) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "This endpoint requires a POST or PUT request.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Println("Reloading configuration via HTTP...")
+	if err := e.Reload(); err != nil {
+		log.Printf("Error reloading configuration: %v", err)
+		http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// QuitHandler serves /-/quit, requesting a shutdown of the exporter on
+// POST. The shutdown itself is up to whoever waits on Quit.
+func (e *Exporter) QuitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "This endpoint requires a POST or PUT request.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Println("Shutting down via HTTP...")
+	e.quitOnce.Do(func() { close(e.quit) })
+	fmt.Fprintln(w, "Requesting termination... Goodbye!")
+}
+
+// Quit returns a channel closed once a shutdown was requested.
+func (e *Exporter) Quit() <-chan struct{} {
+	return e.quit
+}
+
+// Stop ends collection for all scripts.
+func (e *Exporter) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, script := range e.scripts {
+		log.Printf("Stopping collection for %s.", name)
+		script.Stop()
+	}
+}
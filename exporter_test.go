@@ -0,0 +1,227 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherOne returns the only sample of the named family gathered from s.
+func gatherOne(t *testing.T, s *Script, name string) *dto.Metric {
+	t.Helper()
+	families, err := s.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			if len(family.Metric) != 1 {
+				t.Fatalf("%s: got %d samples, want 1", name, len(family.Metric))
+			}
+			return family.Metric[0]
+		}
+	}
+	t.Fatalf("%s not gathered", name)
+	return nil
+}
+
+func TestSetMetricsCompositeParts(t *testing.T) {
+	for _, onDuplicate := range []string{"", DuplicateFirst, DuplicateSum, DuplicateFail} {
+		t.Run("summary/"+onDuplicate, func(t *testing.T) {
+			s, err := NewScript(ScriptConfig{Name: "test", Summaries: []string{"q"}, OnDuplicate: onDuplicate})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.SetMetrics([]Metric{
+				{Name: "q", Labels: map[string]string{"quantile": "0.5"}, Value: 3},
+				{Name: "q_sum", Value: 42},
+				{Name: "q_count", Value: 10},
+			})
+			if err != nil {
+				t.Fatalf("SetMetrics: %v", err)
+			}
+			summary := gatherOne(t, s, "q").GetSummary()
+			if summary.GetSampleCount() != 10 || summary.GetSampleSum() != 42 {
+				t.Errorf("got count %d and sum %v, want 10 and 42", summary.GetSampleCount(), summary.GetSampleSum())
+			}
+		})
+		t.Run("histogram/"+onDuplicate, func(t *testing.T) {
+			s, err := NewScript(ScriptConfig{Name: "test", Histograms: []string{"h"}, OnDuplicate: onDuplicate})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.SetMetrics([]Metric{
+				{Name: "h", Labels: map[string]string{"le": "1"}, Value: 4},
+				{Name: "h", Labels: map[string]string{"le": "+Inf"}, Value: 7},
+				{Name: "h_sum", Value: 5.5},
+				{Name: "h_count", Value: 7},
+			})
+			if err != nil {
+				t.Fatalf("SetMetrics: %v", err)
+			}
+			histogram := gatherOne(t, s, "h").GetHistogram()
+			if histogram.GetSampleCount() != 7 || histogram.GetSampleSum() != 5.5 {
+				t.Errorf("got count %d and sum %v, want 7 and 5.5", histogram.GetSampleCount(), histogram.GetSampleSum())
+			}
+		})
+	}
+}
+
+func TestLimitSeriesComposite(t *testing.T) {
+	s, err := NewScript(ScriptConfig{Name: "test", Histograms: []string{"h"}, MaxSeries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetMetrics([]Metric{
+		{Name: "h", Labels: map[string]string{"le": "1"}, Value: 4},
+		{Name: "h", Labels: map[string]string{"le": "+Inf"}, Value: 7},
+		{Name: "h_sum", Value: 5.5},
+		{Name: "h_count", Value: 7},
+		{Name: "a", Value: 1},
+		{Name: "z", Value: 2},
+	})
+	if err != nil {
+		t.Fatalf("SetMetrics: %v", err)
+	}
+	histogram := gatherOne(t, s, "h").GetHistogram()
+	if histogram.GetSampleCount() != 7 || histogram.GetSampleSum() != 5.5 || len(histogram.GetBucket()) != 1 {
+		t.Errorf("got count %d, sum %v and %d buckets, want 7, 5.5 and 1", histogram.GetSampleCount(), histogram.GetSampleSum(), len(histogram.GetBucket()))
+	}
+	gatherOne(t, s, "a")
+	families, _ := s.Gather()
+	for _, family := range families {
+		if family.GetName() == "z" {
+			t.Errorf("z exported beyond max_series")
+		}
+	}
+}
+
+func TestSetMetricsStaticLabels(t *testing.T) {
+	s, err := NewScript(ScriptConfig{Name: "test", Labels: map[string]string{"datacenter": "x", "team": "db"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetMetrics([]Metric{
+		{Name: "m", Labels: map[string]string{"datacenter": "y"}, Value: 1},
+		{Name: "n", Value: 2},
+	})
+	if err != nil {
+		t.Fatalf("SetMetrics: %v", err)
+	}
+	for name, want := range map[string]map[string]string{
+		"m": {"datacenter": "y", "team": "db"},
+		"n": {"datacenter": "x", "team": "db"},
+	} {
+		got := make(map[string]string)
+		for _, pair := range gatherOne(t, s, name).GetLabel() {
+			got[pair.GetName()] = pair.GetValue()
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("%s: got labels %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name, path string
+		want       int
+	}{
+		{"success", "echo a,b,c,d,e,f,1", 0},
+		{"failure", "echo 'ERROR: db unreachable'; exit 2", 2},
+		{"missing", "/nonexistent/script", -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := ScriptConfig{Name: "test", Path: tc.path, Shell: tc.name != "missing"}
+			s, err := NewScript(sc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.Run(t.Context())
+			if (err != nil) != (tc.want != 0) {
+				t.Errorf("Run: %v", err)
+			}
+			if got := s.Status().ExitCode; got != tc.want {
+				t.Errorf("got exit code %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStopDeletesSeries(t *testing.T) {
+	s, err := NewScript(ScriptConfig{Name: "stopped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backoffSeconds.WithLabelValues("stopped").Set(4)
+	scriptQuarantined.WithLabelValues("stopped").Set(1)
+	skippedRunsTotal.WithLabelValues("stopped").Inc()
+	duplicateSeriesTotal.WithLabelValues("stopped").Inc()
+	truncatedLinesTotal.WithLabelValues("stopped").Inc()
+	memoryLimitedRunsTotal.WithLabelValues("stopped").Inc()
+	droppedSeriesTotal.WithLabelValues("stopped").Inc()
+	counterResetsTotal.WithLabelValues("stopped").Inc()
+	invalidValuesTotal.WithLabelValues("stopped").Inc()
+	parseErrorsTotal.WithLabelValues("stopped").Inc()
+	s.Stop()
+	if backoffSeconds.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_backoff_seconds kept after Stop")
+	}
+	if scriptQuarantined.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_script_quarantined kept after Stop")
+	}
+	if skippedRunsTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_skipped_runs_total kept after Stop")
+	}
+	if parseErrorsTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_parse_errors_total kept after Stop")
+	}
+	if invalidValuesTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_invalid_values_total kept after Stop")
+	}
+	if counterResetsTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_counter_resets_total kept after Stop")
+	}
+	if droppedSeriesTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_dropped_series_total kept after Stop")
+	}
+	if memoryLimitedRunsTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_memory_limited_runs_total kept after Stop")
+	}
+	if truncatedLinesTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_truncated_lines_total kept after Stop")
+	}
+	if duplicateSeriesTotal.DeleteLabelValues("stopped") {
+		t.Error("custom_exporter_duplicate_series_total kept after Stop")
+	}
+}
+
+func TestGatherHelpAcrossScripts(t *testing.T) {
+	e := NewExporter(&Options{})
+	for name, help := range map[string]string{"a": "", "b": "Latency of b.", "c": "Latency of c."} {
+		s, err := NewScript(ScriptConfig{Name: name, MetricOptions: MetricOptions{Help: help}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetMetrics([]Metric{{Name: "latency", Labels: map[string]string{"script": name}, Value: 1}}); err != nil {
+			t.Fatalf("SetMetrics: %v", err)
+		}
+		e.scripts[name] = s
+	}
+
+	families, err := e.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "latency" {
+			continue
+		}
+		if family.GetHelp() != "Latency of b." || len(family.Metric) != 3 {
+			t.Errorf("got help %q and %d samples, want %q and 3", family.GetHelp(), len(family.Metric), "Latency of b.")
+		}
+		return
+	}
+	t.Error("latency not gathered")
+}
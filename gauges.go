@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultMetricName is the name of metrics whose output line does not name
+// them. It is exported as named by the MetricOptions of the script.
+const DefaultMetricName = "prom_custom_custom_metrics"
+
+// GaugeSet collects the gauges and counters of a script, registered in a
+// registry of its own. Samples are exported as constant metrics so that they
+// can carry the timestamp reported by the script. Set builds the samples of
+// a run off to the side and swaps them in at once, so scrapes never see a
+// partial run.
+type GaugeSet struct {
+	Registry *prometheus.Registry
+
+	descs       map[string]*prometheus.Desc
+	schema      map[string][]string
+	expireAfter time.Duration
+
+	mu      sync.RWMutex
+	samples []gaugeSample
+}
+
+// gaugeSample is a sample and the time it expires, if ever.
+type gaugeSample struct {
+	prometheus.Metric
+	expires time.Time
+}
+
+// MetricSchema returns the sorted label names used by each metric name.
+// The default metric always carries the fixed output labels.
+func MetricSchema(metrics []Metric) map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for _, metric := range metrics {
+		name, labels := metric.FullName(), metric.AllLabels()
+		if metric.Type == MetricTypeHistogram || metric.Type == MetricTypeSummary {
+			labels = compositeLabels(metric)
+		}
+		if seen[name] == nil {
+			seen[name] = make(map[string]bool)
+		}
+		for label := range labels {
+			seen[name][label] = true
+		}
+	}
+
+	schema := make(map[string][]string, len(seen))
+	for name, labels := range seen {
+		schema[name] = make([]string, 0, len(labels))
+		for label := range labels {
+			schema[name] = append(schema[name], label)
+		}
+		sort.Strings(schema[name])
+	}
+	return schema
+}
+
+// NewGaugeSet creates the gauges for schema. The default metric is always
+// registered, with the static labels, so that invalid static labels are
+// detected up front.
+func NewGaugeSet(sc ScriptConfig, schema map[string][]string) (*GaugeSet, error) {
+	if _, ok := schema[DefaultMetricName]; !ok {
+		schema = maps.Clone(schema)
+		if schema == nil {
+			schema = make(map[string][]string)
+		}
+		schema[DefaultMetricName] = MetricSchema([]Metric{{Labels: sc.Labels}})[DefaultMetricName]
+	}
+
+	set := &GaugeSet{
+		Registry:    prometheus.NewRegistry(),
+		descs:       make(map[string]*prometheus.Desc, len(schema)),
+		schema:      schema,
+		expireAfter: time.Duration(sc.ExpireAfter),
+	}
+	for name, labelNames := range schema {
+		set.descs[name] = prometheus.NewDesc(sc.MetricName(name), sc.MetricHelp(), labelNames, nil)
+	}
+	if err := set.Registry.Register(set); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Describe implements prometheus.Collector.
+func (g *GaugeSet) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range g.descs {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector. Expired samples are skipped.
+func (g *GaugeSet) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, sample := range g.samples {
+		if sample.expires.IsZero() || now.Before(sample.expires) {
+			ch <- sample.Metric
+		}
+	}
+}
+
+// expires returns when a sample reported at seen expires, or the zero time
+// if samples do not expire.
+func (g *GaugeSet) expires(seen, now time.Time) time.Time {
+	if g.expireAfter <= 0 {
+		return time.Time{}
+	}
+	if seen.IsZero() {
+		seen = now
+	}
+	return seen.Add(g.expireAfter)
+}
+
+// Matches reports whether the gauges were built for schema.
+func (g *GaugeSet) Matches(schema map[string][]string) bool {
+	for name, labelNames := range schema {
+		existing, ok := g.schema[name]
+		if !ok || !slices.Equal(existing, labelNames) {
+			return false
+		}
+	}
+	return true
+}
+
+// Set replaces the samples with metrics. Labels a metric lacks compared to
+// other metrics of the same name are set to the empty string. When several
+// metrics have the same labels, the last one wins.
+func (g *GaugeSet) Set(metrics []Metric) error {
+	now := time.Now()
+	samples := make([]gaugeSample, 0, len(metrics))
+	index := make(map[string]int, len(metrics))
+	composites := newCompositeBuilder()
+	for _, metric := range metrics {
+		if metric.Type == MetricTypeHistogram || metric.Type == MetricTypeSummary {
+			if err := composites.add(metric, g.schema); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := metric.FullName()
+		labels := metric.AllLabels()
+		values := make([]string, len(g.schema[name]))
+		for i, label := range g.schema[name] {
+			values[i] = labels[label]
+		}
+
+		valueType := prometheus.GaugeValue
+		if metric.Type == MetricTypeCounter {
+			if metric.Value < 0 {
+				return fmt.Errorf("counter %s must not be negative, got %v", name, metric.Value)
+			}
+			valueType = prometheus.CounterValue
+		}
+		sample, err := prometheus.NewConstMetric(g.descs[name], valueType, metric.Value, values...)
+		if err != nil {
+			return fmt.Errorf("metric %s: %w", name, err)
+		}
+		if !metric.Timestamp.IsZero() {
+			sample = prometheus.NewMetricWithTimestamp(metric.Timestamp, sample)
+		}
+		if metric.TraceID != "" && valueType == prometheus.CounterValue {
+			exemplar := prometheus.Exemplar{Value: metric.Value, Labels: prometheus.Labels{"trace_id": metric.TraceID}, Timestamp: metric.Timestamp}
+			if sample, err = prometheus.NewMetricWithExemplars(sample, exemplar); err != nil {
+				return fmt.Errorf("metric %s: %w", name, err)
+			}
+		}
+
+		key := name + "\xff" + strings.Join(values, "\xff")
+		if i, ok := index[key]; ok {
+			samples[i] = gaugeSample{sample, g.expires(metric.seen, now)}
+			continue
+		}
+		index[key] = len(samples)
+		samples = append(samples, gaugeSample{sample, g.expires(metric.seen, now)})
+	}
+
+	built, err := composites.build(g.descs, func(seen time.Time) time.Time { return g.expires(seen, now) })
+	if err != nil {
+		return err
+	}
+	samples = append(samples, built...)
+
+	g.mu.Lock()
+	g.samples = samples
+	g.mu.Unlock()
+	return nil
+}
+
+// sortedLabels returns the names of labels in order and their values.
+func sortedLabels(labels map[string]string) (names, values []string) {
+	names = make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values = make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	return names, values
+}
+
+// seriesKey identifies a series by its name and labels.
+func seriesKey(name string, labels map[string]string) string {
+	names, values := sortedLabels(labels)
+	key := name
+	for i := range names {
+		key += "\xff" + names[i] + "=" + values[i]
+	}
+	return key
+}
+
+// metricKey identifies the series of metric, telling the sum and count of
+// a histogram or summary apart from its buckets or quantiles, which share
+// its name once setPart renamed them.
+func metricKey(metric Metric) string {
+	return seriesKey(metric.FullName(), metric.AllLabels()) + "\xff" + metric.part
+}
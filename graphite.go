@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GraphiteMapping translates dotted Graphite paths into a metric name and
+// labels. Match is a glob where * matches a single path component; the
+// components matched are available as $1, $2, ... in Name and Labels.
+type GraphiteMapping struct {
+	Match  string            `yaml:"match"`
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels"`
+
+	re *regexp.Regexp
+}
+
+// compile prepares the mapping and checks its name and labels.
+func (m *GraphiteMapping) compile() error {
+	if m.Match == "" || m.Name == "" {
+		return fmt.Errorf("graphite mapping: match and name are required")
+	}
+	parts := strings.Split(m.Match, ".")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(regexp.QuoteMeta(part), `\*`, `([^.]*)`)
+	}
+	m.re = regexp.MustCompile("^" + strings.Join(parts, `\.`) + "$")
+
+	for name := range m.Labels {
+		if err := CheckLabelName(name); err != nil {
+			return fmt.Errorf("graphite mapping %s: %w", m.Match, err)
+		}
+	}
+	return nil
+}
+
+// apply returns the name and labels of path, or false if it does not match.
+func (m *GraphiteMapping) apply(path string) (string, map[string]string, bool) {
+	match := m.re.FindStringSubmatchIndex(path)
+	if match == nil {
+		return "", nil, false
+	}
+	expand := func(template string) string {
+		return string(m.re.ExpandString(nil, template, path, match))
+	}
+
+	labels := make(map[string]string, len(m.Labels))
+	for name, template := range m.Labels {
+		labels[name] = expand(template)
+	}
+	return sanitizeName(expand(m.Name)), labels, true
+}
+
+// Graphite collects metrics received in the Graphite plaintext protocol,
+// "path value timestamp", over TCP. Paths without a matching mapping are
+// exported with dots replaced by underscores.
+type Graphite struct {
+	Registry *prometheus.Registry
+
+	mu       sync.Mutex
+	mappings []GraphiteMapping
+	series   map[string]*graphiteSeries
+	errors   prometheus.Counter
+}
+
+// graphiteSeries is the last sample received for a series.
+type graphiteSeries struct {
+	name      string
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+// NewGraphite returns a Graphite collector with a registry of its own.
+func NewGraphite() *Graphite {
+	g := &Graphite{
+		Registry: prometheus.NewRegistry(),
+		series:   make(map[string]*graphiteSeries),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "custom_exporter_graphite_errors_total",
+			Help: "Number of malformed Graphite lines received.",
+		}),
+	}
+	g.Registry.MustRegister(g, g.errors)
+	return g
+}
+
+// SetMappings replaces the mappings applied to newly received samples.
+func (g *Graphite) SetMappings(mappings []GraphiteMapping) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.mappings = mappings
+}
+
+// ListenAndServe accepts Graphite connections on the TCP address until
+// listening fails.
+func (g *Graphite) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("graphite: %w", err)
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("graphite: %w", err)
+		}
+		go g.serve(conn)
+	}
+}
+
+func (g *Graphite) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := g.Handle(line); err != nil {
+			g.errors.Inc()
+			log.Printf("Error handling Graphite line %q: %v", line, err)
+		}
+	}
+}
+
+// Handle stores a single line of the form "path value [timestamp]".
+func (g *Graphite) Handle(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return fmt.Errorf("want path, value and timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", fields[1])
+	}
+	var timestamp time.Time
+	if len(fields) == 3 && fields[2] != "-1" && fields[2] != "N" {
+		if timestamp, err = ParseTimestamp(fields[2]); err != nil {
+			return err
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	name, labels := sanitizeName(fields[0]), map[string]string{}
+	for i := range g.mappings {
+		if mappedName, mappedLabels, ok := g.mappings[i].apply(fields[0]); ok {
+			name, labels = mappedName, mappedLabels
+			break
+		}
+	}
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+
+	g.series[seriesKey(name, labels)] = &graphiteSeries{
+		name:      name,
+		labels:    labels,
+		value:     value,
+		timestamp: timestamp,
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector. The collector is unchecked as
+// the metrics are only known once received.
+func (g *Graphite) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (g *Graphite) Collect(ch chan<- prometheus.Metric) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, series := range g.series {
+		names, values := sortedLabels(series.labels)
+
+		desc := prometheus.NewDesc(series.name, "Metric received over Graphite.", names, nil)
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, series.value, values...)
+		if err != nil {
+			metric = prometheus.NewInvalidMetric(desc, err)
+		} else if !series.timestamp.IsZero() {
+			metric = prometheus.NewMetricWithTimestamp(series.timestamp, metric)
+		}
+		ch <- metric
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// securityHeaders are the headers added by -web.security-headers.
+var securityHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+	"Referrer-Policy":         "no-referrer",
+}
+
+// headerList is a flag.Value collecting "Name: value" headers of a
+// repeated flag.
+type headerList []string
+
+// Set implements flag.Value.
+func (l *headerList) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q: use \"Name: value\"", value)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// String implements flag.Value.
+func (l *headerList) String() string {
+	return strings.Join(*l, "; ")
+}
+
+// WithHeaders adds the security headers, if enabled, and the custom
+// headers to the responses of handler, and answers the CORS requests of
+// origins; "*" allows any origin.
+func WithHeaders(handler http.Handler, security, tls bool, custom []string, origins []string) http.Handler {
+	headers := make(http.Header)
+	if security {
+		for name, value := range securityHeaders {
+			headers.Set(name, value)
+		}
+		if tls {
+			headers.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+	}
+	for _, header := range custom {
+		name, value, _ := strings.Cut(header, ":")
+		headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = values
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(origins) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !slices.Contains(origins, "*") && !slices.Contains(origins, origin) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if slices.Contains(origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			// Credentials are only sent to origins allowed by name.
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Values of Options.ReadyAfter.
+const (
+	ReadyAfterConfig = "config"
+	ReadyAfterAny    = "any"
+	ReadyAfterAll    = "all"
+)
+
+// HealthHandler serves /healthz, reporting that the exporter is alive.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "OK")
+}
+
+// ReadyHandler serves /readyz. Once the configuration is loaded the
+// exporter is ready after ReadyAfterConfig; after the first successful run
+// of any script with ReadyAfterAny; or once every script has succeeded
+// with ReadyAfterAll.
+func (e *Exporter) ReadyHandler(readyAfter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e.mu.RLock()
+		var pending []string
+		total := 0
+		for name, script := range e.scripts {
+			if script.Config.Probe {
+				continue // Probes only run on request.
+			}
+			if total++; !script.collected.Load() {
+				pending = append(pending, name)
+			}
+		}
+		e.mu.RUnlock()
+		sort.Strings(pending)
+
+		switch {
+		case readyAfter == ReadyAfterAny && total > 0 && len(pending) == total,
+			readyAfter == ReadyAfterAll && len(pending) > 0:
+			http.Error(w, "Waiting for scripts: "+strings.Join(pending, ", "), http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "OK")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatInflux selects parsing the InfluxDB line protocol.
+const FormatInflux = "influx"
+
+// InfluxParser parses the InfluxDB line protocol, e.g.
+//
+//	weather,location=us-midwest temperature=82,humidity=71i 1465839830100400200
+//
+// Each numeric or boolean field becomes a metric named
+// <measurement>_<field>, or just <measurement> for a field called "value".
+// Tags become labels and the optional timestamp is in nanoseconds. String
+// fields are ignored, as are blank lines and lines starting with #.
+type InfluxParser struct {
+	Values ValuePolicy
+}
+
+// Parse implements Parser.
+func (p InfluxParser) Parse(r io.Reader) ([]Metric, error) {
+	return scanLines(r, func(line string) ([]Metric, error) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return nil, nil
+		}
+		return p.parseLine(line)
+	})
+}
+
+// parseLine parses a single line of the line protocol.
+func (p InfluxParser) parseLine(line string) ([]Metric, error) {
+	sections := splitInflux(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("want measurement, fields and an optional timestamp")
+	}
+
+	series := splitInflux(sections[0], ',')
+	measurement := sanitizeName(unescapeInflux(series[0]))
+	labels := make(map[string]string, len(series)-1)
+	for _, tag := range series[1:] {
+		key, value, ok := cutInflux(tag)
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		name := sanitizeName(unescapeInflux(key))
+		if err := CheckLabelName(name); err != nil {
+			return nil, err
+		}
+		labels[name] = unescapeInflux(value)
+	}
+
+	var timestamp time.Time
+	if len(sections) == 3 {
+		ns, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		timestamp = time.Unix(0, ns)
+	}
+
+	var metrics []Metric
+	for _, field := range splitInflux(sections[1], ',') {
+		key, raw, ok := cutInflux(field)
+		if !ok {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+
+		name := measurement
+		if key = unescapeInflux(key); key != "value" {
+			name += "_" + sanitizeName(key)
+		}
+		if !metricNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid metric name %q", name)
+		}
+
+		var number string
+		switch {
+		case strings.HasPrefix(raw, `"`):
+			continue // String field.
+		case raw == "t" || raw == "T" || raw == "true" || raw == "True" || raw == "TRUE":
+			number = "1"
+		case raw == "f" || raw == "F" || raw == "false" || raw == "False" || raw == "FALSE":
+			number = "0"
+		case strings.HasSuffix(raw, "i") || strings.HasSuffix(raw, "u"):
+			number = raw[:len(raw)-1]
+		default:
+			number = raw
+		}
+
+		value, ok, err := p.Values.Parse(name, number)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		if ok {
+			metrics = append(metrics, Metric{Name: name, Labels: labels, Value: value, Timestamp: timestamp})
+		}
+	}
+	return metrics, nil
+}
+
+// splitInflux splits s at sep, skipping escaped separators and separators
+// inside double quoted string fields.
+func splitInflux(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutInflux splits a key=value pair at the first unescaped =.
+func cutInflux(s string) (key, value string, ok bool) {
+	parts := splitInflux(s, '=')
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], s[len(parts[0])+1:], true
+}
+
+// unescapeInflux removes the backslashes escaping commas, spaces and equal
+// signs.
+func unescapeInflux(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`).Replace(s)
+}
+
+// sanitizeName replaces characters not allowed in metric and label names
+// with underscores.
+func sanitizeName(s string) string {
+	name := []byte(s)
+	for i, b := range name {
+		if !(b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || i > 0 && b >= '0' && b <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
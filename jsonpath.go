@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatJSONPath selects extracting metrics from a single JSON document.
+const FormatJSONPath = "jsonpath"
+
+// JSONPathMetric extracts a metric from a JSON document. Path selects the
+// nodes to export, one sample each. Value and Labels are evaluated for
+// every selected node, which they refer to as @; $ is the document root.
+// Without Value the node itself is the value. The optional Timestamp
+// selects when the value was observed, in Unix seconds or RFC 3339.
+//
+//	name: queue_depth
+//	path: $.queues[*]
+//	value: '@.depth'
+//	timestamp: $.generated_at
+//	labels: {queue: '@.name', region: $.region}
+//
+// Expressions support child names (.name or ['name']), array indexes ([0]),
+// wildcards (.* or [*]) and recursive descent (..name).
+type JSONPathMetric struct {
+	Name      string            `yaml:"name"`
+	Path      string            `yaml:"path"`
+	Value     string            `yaml:"value"`
+	Timestamp string            `yaml:"timestamp"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+// jsonPath is a compiled JSONPath expression.
+type jsonPath struct {
+	relative bool
+	steps    []jsonPathStep
+}
+
+// jsonPathStep is one step of a JSONPath expression. An empty key with
+// index -1 is a wildcard.
+type jsonPathStep struct {
+	key       string
+	index     int
+	recursive bool
+}
+
+// compileJSONPath parses a JSONPath expression starting with $ or @.
+func compileJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") && !strings.HasPrefix(expr, "@") {
+		return jsonPath{}, fmt.Errorf("JSONPath %q must start with $ or @", expr)
+	}
+
+	path := jsonPath{relative: expr[0] == '@'}
+	s := expr[1:]
+	for s != "" {
+		step := jsonPathStep{index: -1}
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[1:]
+			fallthrough
+		case s[0] == '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if step.key = s[:end]; step.key == "*" {
+				step.key = ""
+			} else if step.key == "" {
+				return jsonPath{}, fmt.Errorf("JSONPath %q: empty name", expr)
+			}
+			s = s[end:]
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return jsonPath{}, fmt.Errorf("JSONPath %q: missing ]", expr)
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+			switch {
+			case inner == "*":
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				step.key = inner[1 : len(inner)-1]
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return jsonPath{}, fmt.Errorf("JSONPath %q: invalid index %q", expr, inner)
+				}
+				step.index = index
+			}
+		default:
+			return jsonPath{}, fmt.Errorf("JSONPath %q: unexpected %q", expr, s)
+		}
+		path.steps = append(path.steps, step)
+	}
+	return path, nil
+}
+
+// Select returns the nodes matched by the path, starting from the document
+// root or from the current node.
+func (p jsonPath) Select(root, current any) []any {
+	nodes := []any{root}
+	if p.relative {
+		nodes = []any{current}
+	}
+	for _, step := range p.steps {
+		var next []any
+		for _, node := range nodes {
+			if step.recursive {
+				next = append(next, descendants(node, step)...)
+			} else {
+				next = append(next, step.children(node)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// children returns the children of node selected by the step.
+func (s jsonPathStep) children(node any) []any {
+	switch node := node.(type) {
+	case map[string]any:
+		if s.key != "" {
+			if child, ok := node[s.key]; ok {
+				return []any{child}
+			}
+			return nil
+		}
+		if s.index >= 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		children := make([]any, len(keys))
+		for i, key := range keys {
+			children[i] = node[key]
+		}
+		return children
+	case []any:
+		switch {
+		case s.key != "":
+			return nil
+		case s.index >= 0:
+			if s.index < len(node) {
+				return []any{node[s.index]}
+			}
+			return nil
+		}
+		return node
+	}
+	return nil
+}
+
+// descendants applies the step to node and all nodes below it.
+func descendants(node any, step jsonPathStep) []any {
+	matched := step.children(node)
+	for _, child := range (jsonPathStep{index: -1}).children(node) {
+		matched = append(matched, descendants(child, step)...)
+	}
+	return matched
+}
+
+// jsonScalar formats a scalar JSON value as text; booleans become 1 and 0.
+func jsonScalar(node any) string {
+	switch node := node.(type) {
+	case nil:
+		return ""
+	case string:
+		return node
+	case bool:
+		if node {
+			return "1"
+		}
+		return "0"
+	case json.Number:
+		return node.String()
+	}
+	data, _ := json.Marshal(node)
+	return string(data)
+}
+
+// compiledJSONPathMetric is a JSONPathMetric with compiled expressions.
+type compiledJSONPathMetric struct {
+	name      string
+	path      jsonPath
+	value     *jsonPath
+	timestamp *jsonPath
+	labels    map[string]jsonPath
+}
+
+// JSONPathParser extracts metrics from a single JSON document printed by the
+// script, as configured by JSONPathMetrics.
+type JSONPathParser struct {
+	Values ValuePolicy
+
+	metrics []compiledJSONPathMetric
+}
+
+// NewJSONPathParser compiles the expressions of metrics.
+func NewJSONPathParser(metrics []JSONPathMetric) (*JSONPathParser, error) {
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("the jsonpath format requires jsonpath metrics")
+	}
+
+	p := &JSONPathParser{}
+	for _, m := range metrics {
+		if !metricNameRE.MatchString(m.Name) {
+			return nil, fmt.Errorf("jsonpath: invalid metric name %q", m.Name)
+		}
+		compiled := compiledJSONPathMetric{name: m.Name, labels: make(map[string]jsonPath)}
+		var err error
+		if compiled.path, err = compileJSONPath(m.Path); err != nil {
+			return nil, err
+		}
+		if m.Value != "" {
+			value, err := compileJSONPath(m.Value)
+			if err != nil {
+				return nil, err
+			}
+			compiled.value = &value
+		}
+		if m.Timestamp != "" {
+			timestamp, err := compileJSONPath(m.Timestamp)
+			if err != nil {
+				return nil, err
+			}
+			compiled.timestamp = &timestamp
+		}
+		for name, expr := range m.Labels {
+			if err := CheckLabelName(name); err != nil {
+				return nil, fmt.Errorf("jsonpath %s: %w", m.Name, err)
+			}
+			if compiled.labels[name], err = compileJSONPath(expr); err != nil {
+				return nil, err
+			}
+		}
+		p.metrics = append(p.metrics, compiled)
+	}
+	return p, nil
+}
+
+// Parse implements Parser. Values that cannot be used are reported as
+// malformed, numbered by their metric's position in the configuration.
+func (p *JSONPathParser) Parse(r io.Reader) ([]Metric, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+
+	var metrics []Metric
+	var malformed ParseErrors
+	for i, m := range p.metrics {
+		for _, node := range m.path.Select(doc, doc) {
+			metric := Metric{Name: m.name, Labels: make(map[string]string, len(m.labels))}
+			for name, path := range m.labels {
+				if selected := path.Select(doc, node); len(selected) > 0 {
+					metric.Labels[name] = jsonScalar(selected[0])
+				} else {
+					metric.Labels[name] = ""
+				}
+			}
+
+			valueNode := node
+			if m.value != nil {
+				valueNode = nil
+				if selected := m.value.Select(doc, node); len(selected) > 0 {
+					valueNode = selected[0]
+				}
+			}
+			if m.timestamp != nil {
+				selected := m.timestamp.Select(doc, node)
+				if len(selected) == 0 {
+					malformed = append(malformed, &LineError{Line: i + 1, Err: fmt.Errorf("%s: no timestamp", m.name)})
+					continue
+				}
+				var err error
+				if metric.Timestamp, err = ParseTimestamp(jsonScalar(selected[0])); err != nil {
+					malformed = append(malformed, &LineError{Line: i + 1, Err: fmt.Errorf("%s: %w", m.name, err)})
+					continue
+				}
+			}
+			value, ok, err := p.Values.Parse(metric.stateKey(), jsonScalar(valueNode))
+			if err != nil {
+				malformed = append(malformed, &LineError{Line: i + 1, Err: fmt.Errorf("%s: %w", m.name, err)})
+				continue
+			}
+			if ok {
+				metric.Value = value
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+
+	if len(malformed) > 0 {
+		return metrics, malformed
+	}
+	return metrics, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serviceAccountDir holds the in-cluster credentials of the pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesSource assembles the configuration from ConfigMaps selected by
+// a label, so platform teams can declare scripts per namespace. Each
+// ConfigMap carries a scripts document under Key; script names are prefixed
+// with "<namespace>/<configmap>/" to keep them unique. As anyone allowed to
+// create ConfigMaps in Namespaces chooses what the exporter runs, their
+// scripts must live in ScriptDir and may not use a shell, an interpreter
+// or the dynamic loader variables. The exporter needs RBAC permission to
+// list and watch ConfigMaps in Namespaces.
+type KubernetesSource struct {
+	Namespaces []string
+	Selector   string
+	Key        string
+	ScriptDir  string
+
+	server string
+	// tokenFile is read on every request, as the kubelet rotates the
+	// service account token.
+	tokenFile string
+	client    *http.Client
+
+	mu               sync.Mutex
+	resourceVersions map[string]string
+}
+
+// NewKubernetesSource returns a source using the in-cluster service account.
+func NewKubernetesSource(namespaces []string, selector, key, scriptDir string) (*KubernetesSource, error) {
+	if len(namespaces) == 0 || slices.Contains(namespaces, "") {
+		return nil, fmt.Errorf("kubernetes: the namespaces to read ConfigMaps from are required")
+	}
+	if !filepath.IsAbs(scriptDir) {
+		return nil, fmt.Errorf("kubernetes: script_dir must be an absolute path, got %q", scriptDir)
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes: not running in a cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+
+	tokenFile := filepath.Join(serviceAccountDir, "token")
+	if _, err := os.ReadFile(tokenFile); err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("kubernetes: no certificates in %s/ca.crt", serviceAccountDir)
+	}
+
+	if selector == "" {
+		selector = "custom-exporter/scripts=true"
+	}
+	if key == "" {
+		key = "scripts.yml"
+	}
+	return &KubernetesSource{
+		Namespaces: namespaces,
+		Selector:   selector,
+		Key:        key,
+		ScriptDir:  filepath.Clean(scriptDir),
+		server:     "https://" + net.JoinHostPort(host, port),
+		tokenFile:  tokenFile,
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// configMapList is the subset of a ConfigMapList used by the exporter.
+type configMapList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Data map[string]string `json:"data"`
+	} `json:"items"`
+}
+
+// Fetch implements ConfigSource by merging the scripts of all selected
+// ConfigMaps into a single configuration document.
+func (k *KubernetesSource) Fetch(ctx context.Context) ([]byte, error) {
+	var list configMapList
+	resourceVersions := make(map[string]string, len(k.Namespaces))
+	for _, namespace := range k.Namespaces {
+		resp, err := k.get(ctx, namespace, url.Values{"labelSelector": {k.Selector}})
+		if err != nil {
+			return nil, err
+		}
+		var namespaceList configMapList
+		err = json.NewDecoder(resp.Body).Decode(&namespaceList)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: %w", err)
+		}
+		list.Items = append(list.Items, namespaceList.Items...)
+		resourceVersions[namespace] = namespaceList.Metadata.ResourceVersion
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i].Metadata, list.Items[j].Metadata
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	merged := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, item := range list.Items {
+		data, ok := item.Data[k.Key]
+		if !ok {
+			continue
+		}
+		prefix := item.Metadata.Namespace + "/" + item.Metadata.Name + "/"
+
+		var doc struct {
+			Scripts []yaml.Node `yaml:"scripts"`
+		}
+		if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+			return nil, fmt.Errorf("kubernetes: configmap %s: %w", prefix+k.Key, err)
+		}
+		for i := range doc.Scripts {
+			if err := k.checkScript(&doc.Scripts[i]); err != nil {
+				return nil, fmt.Errorf("kubernetes: configmap %s: %w", prefix+k.Key, err)
+			}
+			prefixScriptNames(&doc.Scripts[i], prefix)
+			merged.Content = append(merged.Content, &doc.Scripts[i])
+		}
+	}
+
+	k.mu.Lock()
+	k.resourceVersions = resourceVersions
+	k.mu.Unlock()
+
+	return yaml.Marshal(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "scripts"},
+		merged,
+	}})
+}
+
+// checkScript rejects a script definition node running anything but a
+// program in ScriptDir, before its name is prefixed.
+func (k *KubernetesSource) checkScript(node *yaml.Node) error {
+	var script struct {
+		Name        string            `yaml:"name"`
+		Path        string            `yaml:"path"`
+		Shell       bool              `yaml:"shell"`
+		Interpreter string            `yaml:"interpreter"`
+		WorkDir     string            `yaml:"workdir"`
+		Env         map[string]string `yaml:"env"`
+	}
+	if err := node.Decode(&script); err != nil {
+		return err
+	}
+	switch {
+	case script.Shell:
+		return fmt.Errorf("script %q: shell is not allowed in ConfigMaps", script.Name)
+	case script.Interpreter != "":
+		return fmt.Errorf("script %q: interpreter is not allowed in ConfigMaps", script.Name)
+	case !k.inScriptDir(script.Path):
+		return fmt.Errorf("script %q: path must be in %s", script.Name, k.ScriptDir)
+	case script.WorkDir != "" && !k.inScriptDir(script.WorkDir):
+		return fmt.Errorf("script %q: workdir must be in %s", script.Name, k.ScriptDir)
+	}
+	for name := range script.Env {
+		if strings.HasPrefix(name, "LD_") || strings.HasPrefix(name, "DYLD_") {
+			return fmt.Errorf("script %q: env %s is not allowed in ConfigMaps", script.Name, name)
+		}
+	}
+	return nil
+}
+
+// inScriptDir reports whether the absolute path is ScriptDir or below it.
+func (k *KubernetesSource) inScriptDir(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	rel, err := filepath.Rel(k.ScriptDir, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// prefixScriptNames prefixes the name of a script definition node, deriving
+// the name from its path when none is set, and the names of the scripts it
+// depends on, which are defined in the same ConfigMap.
+func prefixScriptNames(script *yaml.Node, prefix string) {
+	if script.Kind != yaml.MappingNode {
+		return
+	}
+
+	var path string
+	named := false
+	for i := 0; i+1 < len(script.Content); i += 2 {
+		value := script.Content[i+1]
+		switch script.Content[i].Value {
+		case "name":
+			value.Value = prefix + value.Value
+			named = true
+		case "path":
+			path = value.Value
+		case "depends_on":
+			for _, dependency := range value.Content {
+				dependency.Value = prefix + dependency.Value
+			}
+		}
+	}
+	if named {
+		return
+	}
+	script.Content = append(script.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: "name"},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: prefix + filepath.Base(path)},
+	)
+}
+
+// Watch implements ConfigWatcher. It returns on the first change to the
+// selected ConfigMaps after the last Fetch.
+func (k *KubernetesSource) Watch(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	k.mu.Lock()
+	resourceVersions := k.resourceVersions
+	k.mu.Unlock()
+
+	errs := make(chan error, len(k.Namespaces))
+	for _, namespace := range k.Namespaces {
+		go func() { errs <- k.watch(ctx, namespace, resourceVersions[namespace]) }()
+	}
+	return <-errs
+}
+
+// watch returns on the first change to the selected ConfigMaps of
+// namespace after resourceVersion.
+func (k *KubernetesSource) watch(ctx context.Context, namespace, resourceVersion string) error {
+	for {
+		resp, err := k.get(ctx, namespace, url.Values{
+			"labelSelector":   {k.Selector},
+			"watch":           {"true"},
+			"resourceVersion": {resourceVersion},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Any event, including an ERROR for an expired resourceVersion, is
+		// answered by reloading, which lists the ConfigMaps again.
+		var event json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&event)
+		resp.Body.Close()
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, io.EOF):
+			continue // The API server closed an idle watch.
+		default:
+			return fmt.Errorf("kubernetes: watch: %w", err)
+		}
+	}
+}
+
+func (k *KubernetesSource) get(ctx context.Context, namespace string, query url.Values) (*http.Response, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/configmaps"
+
+	token, err := os.ReadFile(k.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes: GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
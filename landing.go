@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// landingTemplate renders the landing page served at /.
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Custom Exporter</title></head>
+<body>
+<h1>Custom Exporter</h1>
+<p>Version {{.Version}}</p>
+<p><a href="{{.MetricsPath}}">Metrics</a></p>
+<p><a href="/status">Status</a></p>
+<h2>Scripts</h2>
+<table>
+<tr><th>Name</th><th>Path</th><th>Schedule</th></tr>
+{{- range .Scripts}}
+<tr><td>{{.Name}}</td><td>{{.Path}}</td><td>{{.Schedule}}</td></tr>
+{{- else}}
+<tr><td colspan="3">No scripts configured.</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// landingScript describes a script on the landing page.
+type landingScript struct {
+	Name, Path, Schedule string
+}
+
+// LandingPage serves a page at / naming the exporter version and the
+// running scripts, with a link to metricsPath.
+func (e *Exporter) LandingPage(metricsPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		e.mu.RLock()
+		scripts := make([]landingScript, 0, len(e.scripts))
+		for _, script := range e.scripts {
+			sc := script.Config
+			scripts = append(scripts, landingScript{Name: sc.Name, Path: sc.Path, Schedule: DescribeSchedule(sc)})
+		}
+		e.mu.RUnlock()
+		slices.SortFunc(scripts, func(a, b landingScript) int { return strings.Compare(a.Name, b.Name) })
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := landingTemplate.Execute(w, struct {
+			Version, MetricsPath string
+			Scripts              []landingScript
+		}{Version, metricsPath, scripts})
+		if err != nil {
+			log.Printf("Error rendering landing page: %v", err)
+		}
+	}
+}
+
+// DescribeSchedule describes when the script of sc runs, e.g. "every 1m0s"
+// or "on scrape".
+func DescribeSchedule(sc ScriptConfig) string {
+	switch {
+	case sc.OnScrape:
+		return "on scrape"
+	case sc.Probe:
+		return "on probe"
+	case sc.Schedule != "":
+		return sc.Schedule
+	}
+	return "every " + time.Duration(sc.Interval).String()
+}
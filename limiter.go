@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RunLimiter limits the number of scripts running at the same time across
+// all scripts. Runs beyond the limit wait in a queue.
+type RunLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	running int
+}
+
+// NewRunLimiter returns a limiter without a limit.
+func NewRunLimiter() *RunLimiter {
+	l := &RunLimiter{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// SetLimit changes the limit. Zero means no limit.
+func (l *RunLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// Acquire waits until a run may start or ctx is done.
+func (l *RunLimiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	queuedRuns.Inc()
+	defer queuedRuns.Dec()
+	start := time.Now()
+	for l.limit > 0 && l.running >= l.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	runQueueWaitSeconds.Observe(time.Since(start).Seconds())
+	l.running++
+	return nil
+}
+
+// Release ends a run started with Acquire.
+func (l *RunLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.cond.Broadcast()
+}
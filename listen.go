@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks listen addresses of Unix domain sockets.
+const unixPrefix = "unix://"
+
+// ParseListenAddress checks address and returns it in canonical form:
+// host:port, with IPv6 hosts in brackets, or unix:///path/to/socket.
+func ParseListenAddress(address string) (string, error) {
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if path == "" {
+			return "", fmt.Errorf("invalid listen address %q: missing socket path", address)
+		}
+		return address, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: use host:port, [ipv6]:port or unix:///path", address)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid listen address %q: invalid IPv6 address %q", address, host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Listen opens a listener on address, either host:port for TCP or
+// unix:///path/to/socket for a Unix domain socket. A socket file left
+// behind by a previous run is removed first.
+func Listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else {
+			_ = os.Remove(path)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// sdListenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const sdListenFDsStart = 3
+
+// SystemdListeners returns the sockets passed by systemd socket activation,
+// as described in sd_listen_fds(3). The environment variables passing them
+// are removed so that scripts do not inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(key)
+	}
+	if pid != os.Getpid() || count < 1 {
+		return nil, fmt.Errorf("no sockets passed by systemd; -web.systemd-socket requires a .socket unit")
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := range count {
+		fd := sdListenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// FileListener duplicates the descriptor with close-on-exec, so
+		// that scripts do not inherit it, and the original is closed.
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("invalid socket %s passed by systemd: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -1,22 +1,28 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 // Metric represents the structure of a metric to be exported.
 type Metric struct {
+	// Name is the metric name. Metrics without a name are exported as
+	// DefaultMetricName with the fixed fields below as labels.
+	Name string
+
 	Component       string
 	ProcessName     string
 	ApplicationName string
@@ -24,141 +30,414 @@ type Metric struct {
 	DomainName      string
 	MonType         string
 	Value           float64
+
+	// Labels holds additional labels beyond the fixed fields above.
+	Labels map[string]string
+
+	// Timestamp is the time the value was observed, if the script reported
+	// one. Otherwise the sample is exported without a timestamp.
+	Timestamp time.Time
+
+	// TraceID links the value to a trace. It is exported as an exemplar of
+	// counters; OpenMetrics allows no exemplars on gauges.
+	TraceID string
+
+	// Type is MetricTypeGauge (or empty), MetricTypeCounter, or
+	// MetricTypeHistogram or MetricTypeSummary for a part of a histogram or
+	// summary.
+	Type string
+
+	// part is "sum" or "count" for the sum and count of a histogram or
+	// summary, and empty for its buckets and quantiles.
+	part string
+
+	// seen is when a metric kept by expire_after was last reported. Zero
+	// means now.
+	seen time.Time
 }
 
-// GetArgs retrieves command line arguments for script execution.
-func GetArgs() (string, string, time.Duration) {
-	if len(os.Args) != 7 {
-		UsageError()
-	}
+// Metric types.
+const (
+	MetricTypeGauge     = "gauge"
+	MetricTypeCounter   = "counter"
+	MetricTypeHistogram = "histogram"
+	MetricTypeSummary   = "summary"
+)
 
-	if os.Args[1] != "-script" || os.Args[3] != "-port" || os.Args[5] != "-timeout" {
-		UsageError()
+// FullName returns the name the metric is exported as.
+func (m Metric) FullName() string {
+	if m.Name == "" && m.Type == MetricTypeCounter {
+		return DefaultMetricName + "_total"
+	}
+	if m.Name == "" {
+		return DefaultMetricName
 	}
+	return m.Name
+}
 
-	timeout := StringToDuration(os.Args[6])
-	return os.Args[2], os.Args[4], timeout
+// setField sets the fixed field called name and reports whether there is
+// one.
+func (m *Metric) setField(name, value string) bool {
+	switch name {
+	case "component":
+		m.Component = value
+	case "process_name":
+		m.ProcessName = value
+	case "application_name":
+		m.ApplicationName = value
+	case "env":
+		m.Env = value
+	case "domain_name":
+		m.DomainName = value
+	case "mon_type":
+		m.MonType = value
+	default:
+		return false
+	}
+	return true
 }
 
-// UsageError displays usage instructions and exits.
-func UsageError() {
-	log.Fatal(`ERROR: Invalid arguments provided. Usage:
-custom_exporter -script <script_path> -port <port> -timeout <seconds>
-`)
+// AllLabels returns the labels of the metric: the fixed fields for metrics
+// without a name, plus the additional labels.
+func (m Metric) AllLabels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	if m.Name == "" {
+		labels["component"] = m.Component
+		labels["process_name"] = m.ProcessName
+		labels["application_name"] = m.ApplicationName
+		labels["env"] = m.Env
+		labels["domain_name"] = m.DomainName
+		labels["mon_type"] = m.MonType
+	}
+	for name, value := range m.Labels {
+		labels[name] = value
+	}
+	return labels
 }
 
-// StringToDuration converts a string to time.Duration.
-func StringToDuration(s string) time.Duration {
-	value, err := strconv.Atoi(s)
-	if err != nil {
-		log.Fatalf("ERROR: Invalid timeout value: %v", err)
+// OutputLabels are the label names read from each line of script output.
+var OutputLabels = []string{"component", "process_name", "application_name", "env", "domain_name", "mon_type"}
+
+// GetArgs parses the arguments of the named subcommand, including
+// environment overrides, into Options.
+func GetArgs(name string, args []string) *Options {
+	fs, opts := NewFlagSet(filepath.Base(os.Args[0]) + " " + name)
+	if err := ParseFlags(fs, args); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	if fs.NArg() > 0 {
+		log.Fatalf("ERROR: unexpected argument %q", fs.Arg(0))
 	}
-	return time.Duration(value) * time.Second
+	return opts
 }
 
-// CheckCmdOutput validates the output of the custom script.
-func CheckCmdOutput(fields []string) {
-	if len(fields) != 7 {
-		log.Fatal(`ERROR: Custom script output must have exactly six fields:
-component, process_name, application_name, env, domain_name, mon_type, metric_value`)
+// NewCommand builds the command line for a script. In shell mode Path is
+// run through "sh -c" and Args become the positional parameters $1, $2, ...
+// With an interpreter, Path and Args are passed to it. The process is killed
+// when ctx is done.
+func NewCommand(ctx context.Context, sc ScriptConfig) *exec.Cmd {
+	var cmd *exec.Cmd
+	switch {
+	case sc.Shell:
+		cmd = exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", sc.Path, sc.Name}, sc.Args...)...)
+	case sc.Interpreter != "":
+		argv := append(strings.Fields(sc.Interpreter), sc.Path)
+		cmd = exec.CommandContext(ctx, argv[0], append(argv[1:], sc.Args...)...)
+	default:
+		cmd = exec.CommandContext(ctx, sc.Path, sc.Args...)
 	}
+	cmd.Dir = sc.WorkDir
+	cmd.Env = sc.Environ()
+	return cmd
 }
 
-// ExecuteCommand runs the specified command and returns its output.
-func ExecuteCommand(script string) ([]Metric, error) {
-	cmd := exec.Command(script)
-	stdout, err := cmd.StdoutPipe()
+// ExecuteCommand runs the specified script and returns its output. When
+// malformed lines are skipped, they are reported as ParseErrors along with
+// the metrics of the valid lines.
+func ExecuteCommand(ctx context.Context, sc ScriptConfig) ([]Metric, error) {
+	parser, err := NewParser(sc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start command: %w", err)
+	var metrics []Metric
+	var malformed ParseErrors
+	err = executeCommand(ctx, sc, func(stdout io.Reader) (err error) {
+		metrics, err = parser.Parse(stdout)
+		if sc.OnParseError == ParseErrorSkip && errors.As(err, &malformed) {
+			return nil
+		}
+		return err
+	})
+	if sc.Format == FormatNagios {
+		var state Metric
+		if state, err = nagiosState(sc, err); err == nil {
+			metrics = append(metrics, state)
+		}
 	}
+	switch {
+	case err != nil:
+		return nil, err
+	case len(malformed) > 0:
+		return metrics, malformed
+	}
+	return metrics, nil
+}
 
-	var metrics []Metric
-	scanner := bufio.NewScanner(stdout)
+// ExecutePassthrough runs a script printing the Prometheus text format and
+// returns the metric families it printed.
+func ExecutePassthrough(ctx context.Context, sc ScriptConfig) ([]*dto.MetricFamily, error) {
+	var families []*dto.MetricFamily
+	err := executeCommand(ctx, sc, func(stdout io.Reader) (err error) {
+		families, err = ParsePassthrough(stdout, sc.Labels)
+		return err
+	})
+	return families, err
+}
+
+// DefaultMaxLineBytes is the longest output line accepted from scripts that
+// do not set max_line_bytes.
+const DefaultMaxLineBytes = 1 << 20
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Split(line, ",")
-		CheckCmdOutput(fields)
+// outputLimiter fails reading once a line or the whole output of a script
+// exceeds its limits, and ends the output after maxLines lines.
+type outputLimiter struct {
+	r         io.Reader
+	maxLine   int
+	maxOutput int
+	maxLines  int
+
+	line      int
+	lines     int
+	total     int
+	truncated int
+}
+
+func (l *outputLimiter) Read(p []byte) (int, error) {
+	if l.maxLines > 0 && l.lines >= l.maxLines {
+		return 0, io.EOF
+	}
+	n, err := l.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.line = 0
+			if l.lines++; l.maxLines > 0 && l.lines >= l.maxLines {
+				l.truncated += bytes.Count(p[i+1:n], []byte("\n"))
+				l.total += n
+				return i + 1, io.EOF
+			}
+			continue
+		}
+		if l.line++; l.line > l.maxLine {
+			return n, fmt.Errorf("output line longer than %d bytes", l.maxLine)
+		}
+	}
+	if l.total += n; l.maxOutput > 0 && l.total > l.maxOutput {
+		return n, fmt.Errorf("output longer than %d bytes", l.maxOutput)
+	}
+	return n, err
+}
 
-		value, err := strconv.ParseFloat(strings.TrimSpace(fields[6]), 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid metric value: %v", err)
+// drain reads and counts the lines beyond maxLines, so that the script can
+// finish writing its output.
+func (l *outputLimiter) drain() error {
+	if l.maxLines <= 0 || l.lines < l.maxLines {
+		return nil
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := l.r.Read(buf)
+		l.truncated += bytes.Count(buf[:n], []byte("\n"))
+		if l.total += n; l.maxOutput > 0 && l.total > l.maxOutput {
+			return fmt.Errorf("output longer than %d bytes", l.maxOutput)
 		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
 
-		metrics = append(metrics, Metric{
-			Component:       strings.TrimSpace(fields[0]),
-			ProcessName:     strings.TrimSpace(fields[1]),
-			ApplicationName: strings.TrimSpace(fields[2]),
-			Env:             strings.TrimSpace(fields[3]),
-			DomainName:      strings.TrimSpace(fields[4]),
-			MonType:         strings.TrimSpace(fields[5]),
-			Value:           value,
-		})
+// executeCommand starts the script and hands its stdout to read. When read
+// fails, including when the output exceeds the limits of the script, the
+// script is killed. The output is also captured into the ScriptOutput of
+// ctx, if any.
+func executeCommand(ctx context.Context, sc ScriptConfig, read func(io.Reader) error) error {
+	cmd := NewCommand(ctx, sc)
+	output := outputFrom(ctx)
+	if output != nil {
+		cmd.Stderr = &output.stderr
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout pipe: %w", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading command output: %w", err)
+	if err := cmd.Start(); err != nil {
+		return &commandError{fmt.Errorf("failed to start command: %w", err), -1}
 	}
 
-	return metrics, nil
+	var r io.Reader = stdout
+	if output != nil {
+		r = io.TeeReader(stdout, &output.stdout)
+	}
+	limiter := &outputLimiter{r: r, maxLine: sc.MaxLineBytes, maxOutput: sc.MaxOutputBytes, maxLines: sc.MaxLines}
+	if limiter.maxLine == 0 {
+		limiter.maxLine = DefaultMaxLineBytes
+	}
+	err = read(limiter)
+	if err == nil {
+		err = limiter.drain()
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return &commandError{err, cmd.ProcessState.ExitCode()}
+	}
+	if limiter.truncated > 0 {
+		truncatedLinesTotal.WithLabelValues(sc.Name).Add(float64(limiter.truncated))
+		log.Printf("Truncated output of %s after %d lines, ignoring %d lines", sc.Name, sc.MaxLines, limiter.truncated)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return &commandError{fmt.Errorf("command failed: %w", err), cmd.ProcessState.ExitCode()}
+	}
+	return nil
 }
 
-// UpdateMetrics updates Prometheus metrics from the executed command.
-func UpdateMetrics(script string, gauge *prometheus.GaugeVec, timeout time.Duration) {
+// commandError is an error running a script along with its exit status,
+// -1 if it did not start or was killed by a signal.
+type commandError struct {
+	err      error
+	exitCode int
+}
+
+func (e *commandError) Error() string { return e.err.Error() }
+func (e *commandError) Unwrap() error { return e.err }
+
+// ExitCode returns the exit status of the script.
+func (e *commandError) ExitCode() int { return e.exitCode }
+
+// UpdateMetrics updates Prometheus metrics from the executed command until
+// ctx is done, starting after StartDelay and a random delay of up to
+// Jitter. Runs start at a fixed rate, every interval after the first one
+// regardless of how long they take, and never overlap: the runs due while a
+// run takes longer than the interval are skipped. Failed runs are retried
+// with an exponential backoff, reset by the next successful run; after
+// QuarantineAfter failures in a row the script is quarantined and only
+// probed every QuarantineProbe. Scripts with a cron schedule only run at
+// the scheduled times and are not retried early on failure. Trigger runs
+// the script right away.
+func UpdateMetrics(ctx context.Context, script *Script) {
+	sc := script.Config
+	schedule := sc.CronSchedule()
+	if schedule == nil && !sleep(ctx, sc.FirstRunDelay()) {
+		return
+	}
+	failures := 0
+	if sc.QuarantineAfter > 0 {
+		scriptQuarantined.WithLabelValues(sc.Name).Set(0)
+	}
+	interval := time.Duration(sc.Interval)
+	next := time.Now() // Start of the next run of an interval schedule
 	for {
-		metrics, err := ExecuteCommand(script)
-		if err != nil {
-			log.Printf("Error executing command: %v", err)
-			time.Sleep(5 * time.Second) // Retry after a delay on error
-			continue
+		if schedule != nil {
+			now := time.Now()
+			due := schedule.Next(now).Add(sc.JitterDelay())
+			script.scheduled(due)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(due.Sub(now)):
+			case <-script.trigger:
+			}
+		}
+
+		start := time.Now()
+		err := script.Run(ctx)
+		if schedule == nil {
+			// Keep the cadence of the previous runs, skipping the runs that
+			// were due while the script was still running.
+			next = next.Add(interval)
+			if now := time.Now(); !now.Before(next) {
+				skipped := int(now.Sub(next)/interval) + 1
+				skippedRunsTotal.WithLabelValues(sc.Name).Add(float64(skipped))
+				log.Printf("Run of %s took %v, longer than its interval; skipped %d runs", sc.Name, now.Sub(start).Round(time.Millisecond), skipped)
+				next = next.Add(time.Duration(skipped) * interval)
+			}
+		}
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			failures++
+			log.Printf("Error executing command %s: %v", sc.Name, err)
+			if schedule == nil {
+				delay := sc.Backoff(failures)
+				if sc.QuarantineAfter > 0 && failures >= sc.QuarantineAfter {
+					if !script.quarantined.Swap(true) {
+						log.Printf("Quarantining %s after %d consecutive failures", sc.Name, failures)
+						scriptQuarantined.WithLabelValues(sc.Name).Set(1)
+					}
+					delay = sc.ProbeInterval()
+				}
+				backoffSeconds.WithLabelValues(sc.Name).Set(delay.Seconds())
+				next = time.Now().Add(delay) // The cadence restarts with the retry.
+			}
+		default:
+			failures = 0
+			backoffSeconds.WithLabelValues(sc.Name).Set(0)
+			if script.quarantined.Swap(false) {
+				log.Printf("Releasing %s from quarantine", sc.Name)
+				scriptQuarantined.WithLabelValues(sc.Name).Set(0)
+			}
+			log.Printf("Metrics updated successfully for %s.", sc.Name)
 		}
 
-		// Reset gauge values before updating
-		gauge.Reset()
-
-		// Update Prometheus metrics
-		for _, metric := range metrics {
-			gauge.With(prometheus.Labels{
-				"component":        metric.Component,
-				"process_name":     metric.ProcessName,
-				"application_name": metric.ApplicationName,
-				"env":              metric.Env,
-				"domain_name":      metric.DomainName,
-				"mon_type":         metric.MonType,
-			}).Set(metric.Value)
+		if schedule != nil {
+			continue
+		}
+		script.scheduled(next)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		case <-script.trigger:
+			next = time.Now()
+		case <-script.resume:
+			next = time.Now()
+			failures = 0
+			script.quarantined.Store(false)
+			scriptQuarantined.WithLabelValues(sc.Name).Set(0)
 		}
+	}
+}
 
-		log.Println("Metrics updated successfully.")
-		time.Sleep(timeout) // Use the timeout value for sleep duration
+// sleep waits for d and reports whether ctx is still active.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
-// Main function to set up the HTTP server and start metrics collection.
+// Main function dispatching to the requested subcommand. Without a known
+// subcommand the arguments are handed to "run" for backward compatibility.
 func main() {
-	script, portStr, timeout := GetArgs()
-	port := fmt.Sprintf(":%s", portStr)
-
-	gauge := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name:      "custom_metrics",
-			Help:      "Custom metrics from script execution",
-			Namespace: "prom",
-			Subsystem: "custom",
-		},
-		[]string{"component", "process_name", "application_name", "env", "domain_name", "mon_type"},
-	)
-
-	prometheus.MustRegister(gauge)
-	http.Handle("/metrics", promhttp.Handler())
-
-	go UpdateMetrics(script, gauge, timeout)
-
-	log.Printf("Starting server on port %s...", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	cmd, args := FindCommand("run"), os.Args[1:]
+	if len(args) > 0 {
+		if c := FindCommand(args[0]); c != nil {
+			cmd, args = c, args[1:]
+		}
+	}
+
+	if err := cmd.Run(args); err != nil {
+		log.Fatalf("ERROR: %v", err)
 	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ByteSize is a number of bytes, parsed from an integer with an optional
+// unit such as 512MiB or 2GB.
+type ByteSize int64
+
+// byteUnits are the units accepted by ByteSize, longest suffix first.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// Set implements flag.Value.
+func (b *ByteSize) Set(s string) error {
+	s = strings.TrimSpace(s)
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q: use bytes or a size such as 512MiB", s)
+	}
+	*b = ByteSize(n * unit)
+	return nil
+}
+
+// String implements flag.Value.
+func (b *ByteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// metricsSize estimates the memory held by metrics.
+func metricsSize(metrics []Metric) int64 {
+	size := int64(len(metrics)) * int64(unsafe.Sizeof(Metric{}))
+	for _, m := range metrics {
+		size += int64(len(m.Name) + len(m.Component) + len(m.ProcessName) + len(m.ApplicationName) +
+			len(m.Env) + len(m.DomainName) + len(m.MonType) + len(m.TraceID))
+		for name, value := range m.Labels {
+			size += int64(len(name)+len(value)) + 2*int64(unsafe.Sizeof(""))
+		}
+	}
+	return size
+}
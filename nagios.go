@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// FormatNagios selects parsing the output and exit code of Nagios plugins.
+const FormatNagios = "nagios"
+
+// Nagios plugin states, as returned in the exit code.
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// NagiosParser parses the performance data of Nagios plugins, e.g.
+//
+//	OK - load average: 0.50 | load1=0.5;1;2;0; 'disk /'=81%;80;90
+//
+// Every value is exported as nagios_perfdata with the perfdata label and
+// its unit as labels; plain numeric thresholds and bounds are exported as
+// nagios_perfdata_warning, _critical, _min and _max. All metrics carry the
+// script name as check label.
+type NagiosParser struct {
+	Check  string
+	Values ValuePolicy
+}
+
+// Parse implements Parser. Performance data follows the first | of the
+// output and may continue on later lines after another |.
+func (p NagiosParser) Parse(r io.Reader) ([]Metric, error) {
+	var metrics []Metric
+	var malformed ParseErrors
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, math.MaxInt) // Line length is limited by ExecuteCommand.
+
+	for n := 1; scanner.Scan(); n++ {
+		_, perfdata, found := strings.Cut(scanner.Text(), "|")
+		if !found {
+			continue
+		}
+		for _, item := range splitPerfdata(perfdata) {
+			parsed, err := p.parseItem(item)
+			if err != nil {
+				malformed = append(malformed, &LineError{Line: n, Err: err})
+				continue
+			}
+			metrics = append(metrics, parsed...)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading command output: %w", err)
+	}
+	if len(malformed) > 0 {
+		return metrics, malformed
+	}
+	return metrics, nil
+}
+
+// splitPerfdata splits performance data at spaces outside single quotes.
+func splitPerfdata(s string) []string {
+	var items []string
+	var item strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			item.WriteRune(r)
+		case r == ' ' && !quoted:
+			if item.Len() > 0 {
+				items = append(items, item.String())
+				item.Reset()
+			}
+		default:
+			item.WriteRune(r)
+		}
+	}
+	if item.Len() > 0 {
+		items = append(items, item.String())
+	}
+	return items
+}
+
+// parseItem parses a single 'label'=value[UOM];[warn];[crit];[min];[max].
+func (p NagiosParser) parseItem(item string) ([]Metric, error) {
+	i := strings.LastIndex(item, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid perfdata %q: want label=value", item)
+	}
+	label := item[:i]
+	if len(label) >= 2 && label[0] == '\'' && label[len(label)-1] == '\'' {
+		label = strings.ReplaceAll(label[1:len(label)-1], "''", "'")
+	}
+
+	fields := strings.Split(item[i+1:], ";")
+	number := strings.TrimRightFunc(fields[0], func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.')
+	})
+	unit := fields[0][len(number):]
+	if number == "" {
+		unit = "" // The value is "U" or another non-numeric placeholder.
+		number = fields[0]
+	}
+
+	labels := map[string]string{"check": p.Check, "label": label, "unit": unit}
+	value, ok, err := p.Values.Parse("nagios_perfdata", number)
+	if err != nil {
+		return nil, fmt.Errorf("perfdata %s: %w", label, err)
+	}
+	var metrics []Metric
+	if ok {
+		metrics = append(metrics, Metric{Name: "nagios_perfdata", Labels: labels, Value: value})
+	}
+
+	for j, suffix := range []string{"warning", "critical", "min", "max"} {
+		if j+1 >= len(fields) {
+			break
+		}
+		// Thresholds can be ranges such as "10:20"; only plain numbers are
+		// exported.
+		if v, err := strconv.ParseFloat(fields[j+1], 64); err == nil {
+			metrics = append(metrics, Metric{Name: "nagios_perfdata_" + suffix, Labels: labels, Value: v})
+		}
+	}
+	return metrics, nil
+}
+
+// nagiosState turns the exit status of a Nagios plugin into the
+// nagios_state metric. Exit codes 0 to 3 are plugin states rather than
+// failures; other errors are returned unchanged.
+func nagiosState(sc ScriptConfig, err error) (Metric, error) {
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		return Metric{}, err
+	}
+	if code < 0 || code >= len(nagiosStates) {
+		return Metric{}, err
+	}
+
+	return Metric{
+		Name:   "nagios_state",
+		Labels: map[string]string{"check": sc.Name, "state": nagiosStates[code]},
+		Value:  float64(code),
+	}, nil
+}
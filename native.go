@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// NativeHistogram aggregates the values of a metric, each taken as an
+// observation, into a Prometheus native histogram. Observations accumulate
+// across runs, like those of an instrumented application, as long as the
+// label set is reported at least every expire_after.
+type NativeHistogram struct {
+	// Metric is the metric name or, for lines without a name, the mon_type
+	// observed.
+	Metric string `yaml:"metric"`
+
+	// Name is the name of the histogram. It defaults to Metric.
+	Name string `yaml:"name"`
+
+	// BucketFactor is the maximum ratio between the bounds of adjacent
+	// buckets. Defaults to 1.1.
+	BucketFactor float64 `yaml:"bucket_factor"`
+
+	// MaxBuckets limits the number of buckets. Defaults to 160.
+	MaxBuckets uint32 `yaml:"max_buckets"`
+}
+
+// name returns the name of the histogram.
+func (h NativeHistogram) name() string {
+	if h.Name == "" {
+		return h.Metric
+	}
+	return h.Name
+}
+
+// check checks the histogram definition.
+func (h NativeHistogram) check() error {
+	if h.Metric == "" || !metricNameRE.MatchString(h.name()) {
+		return fmt.Errorf("native histogram: metric and a valid name are required")
+	}
+	if h.BucketFactor != 0 && h.BucketFactor <= 1 {
+		return fmt.Errorf("native histogram %s: bucket_factor must be greater than 1", h.name())
+	}
+	return nil
+}
+
+// nativeHistograms holds the native histograms of a script, one per label
+// set, registered in a registry of their own. mu keeps scrapes from seeing
+// part of the observations of a run.
+type nativeHistograms struct {
+	Registry *prometheus.Registry
+
+	config     ScriptConfig
+	mu         sync.RWMutex
+	histograms map[string]*nativeHistogram
+}
+
+// nativeHistogram is the histogram of a label set and the time of its last
+// observation.
+type nativeHistogram struct {
+	prometheus.Histogram
+	seen time.Time
+}
+
+func newNativeHistograms(sc ScriptConfig) *nativeHistograms {
+	return &nativeHistograms{
+		Registry:   prometheus.NewRegistry(),
+		config:     sc,
+		histograms: make(map[string]*nativeHistogram),
+	}
+}
+
+// Observe records the metrics of native histograms as observations and
+// returns the other metrics. The histograms of label sets not observed
+// within ExpireAfter, or in this run if unset, are removed. New label sets
+// beyond MaxSeries are dropped.
+func (n *nativeHistograms) Observe(metrics []Metric, now time.Time) []Metric {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rest := metrics[:0]
+	dropped := 0
+	for _, metric := range metrics {
+		key := metric.Name
+		if key == "" {
+			key = metric.MonType
+		}
+		i := slices.IndexFunc(n.config.NativeHistograms, func(h NativeHistogram) bool { return h.Metric == key })
+		if i < 0 {
+			rest = append(rest, metric)
+			continue
+		}
+
+		histogram, err := n.histogram(n.config.NativeHistograms[i], metric)
+		if err != nil {
+			log.Printf("Error observing %s of %s: %v", key, n.config.Name, err)
+			continue
+		}
+		if histogram == nil {
+			dropped++
+			continue
+		}
+		histogram.Observe(metric.Value)
+		histogram.seen = now
+	}
+
+	expireAfter := time.Duration(n.config.ExpireAfter)
+	for key, histogram := range n.histograms {
+		if histogram.seen.Before(now) && now.Sub(histogram.seen) >= expireAfter {
+			n.Registry.Unregister(histogram)
+			delete(n.histograms, key)
+		}
+	}
+	if dropped > 0 {
+		droppedSeriesTotal.WithLabelValues(n.config.Name).Add(float64(dropped))
+		log.Printf("Dropped %d native histogram series of %s exceeding max_series %d", dropped, n.config.Name, n.config.MaxSeries)
+	}
+	return rest
+}
+
+// Len returns the number of native histograms.
+func (n *nativeHistograms) Len() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.histograms)
+}
+
+// Gather implements prometheus.Gatherer, waiting for the observations of a
+// run in progress.
+func (n *nativeHistograms) Gather() ([]*dto.MetricFamily, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.Registry.Gather()
+}
+
+// histogram returns the histogram of the labels of metric kept by
+// KeepLabels and DropLabels, creating it with the first observation, or nil
+// if that would exceed MaxSeries.
+func (n *nativeHistograms) histogram(h NativeHistogram, metric Metric) (*nativeHistogram, error) {
+	metric = FilterLabels([]Metric{metric}, n.config.KeepLabels, n.config.DropLabels)[0]
+	labels := make(prometheus.Labels)
+	for name, value := range metric.AllLabels() {
+		if value != "" && !(metric.Name == "" && name == "mon_type") {
+			labels[name] = value
+		}
+	}
+	key := seriesKey(h.name(), labels)
+	if histogram, ok := n.histograms[key]; ok {
+		return histogram, nil
+	}
+	if n.config.MaxSeries > 0 && len(n.histograms) >= n.config.MaxSeries {
+		return nil, nil
+	}
+
+	factor, maxBuckets := h.BucketFactor, h.MaxBuckets
+	if factor == 0 {
+		factor = 1.1
+	}
+	if maxBuckets == 0 {
+		maxBuckets = 160
+	}
+	histogram := &nativeHistogram{Histogram: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:                           n.config.MetricName(h.name()),
+		Help:                           n.config.MetricHelp(),
+		ConstLabels:                    mergeLabels(n.config.Labels, labels),
+		NativeHistogramBucketFactor:    factor,
+		NativeHistogramMaxBucketNumber: maxBuckets,
+	})}
+	if err := n.Registry.Register(histogram); err != nil {
+		return nil, err
+	}
+	n.histograms[key] = histogram
+	return histogram, nil
+}
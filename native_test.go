@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// nativeLabelSets returns the label sets of the gathered native histograms
+// named name, as their zone label.
+func nativeLabelSets(t *testing.T, n *nativeHistograms, name string) []string {
+	t.Helper()
+	families, err := n.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var zones []string
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.Metric {
+			zone := "-"
+			for _, pair := range m.Label {
+				if pair.GetName() == "zone" {
+					zone = pair.GetValue()
+				}
+			}
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+func TestNativeHistogramsExpire(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name        string
+		expireAfter time.Duration
+		want        int
+	}{
+		{"pruned", 0, 1},
+		{"retained", time.Minute, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := newNativeHistograms(ScriptConfig{Name: "test", ExpireAfter: Duration(tc.expireAfter), NativeHistograms: []NativeHistogram{{Metric: "latency"}}})
+			n.Observe([]Metric{{Name: "latency", Labels: map[string]string{"zone": "a"}, Value: 1}}, now)
+			n.Observe([]Metric{{Name: "latency", Labels: map[string]string{"zone": "b"}, Value: 1}}, now.Add(time.Second))
+			if got := nativeLabelSets(t, n, "latency"); len(got) != tc.want {
+				t.Errorf("got zones %v, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNativeHistogramsLabelsAndLimit(t *testing.T) {
+	s, err := NewScript(ScriptConfig{
+		Name:             "test",
+		DropLabels:       []string{"zone"},
+		MaxSeries:        1,
+		NativeHistograms: []NativeHistogram{{Metric: "latency"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetMetrics([]Metric{
+		{Name: "latency", Labels: map[string]string{"zone": "a"}, Value: 1},
+		{Name: "latency", Labels: map[string]string{"zone": "b"}, Value: 2},
+		{Name: "up", Value: 1},
+	})
+	if err != nil {
+		t.Fatalf("SetMetrics: %v", err)
+	}
+	if got := nativeLabelSets(t, s.natives, "latency"); len(got) != 1 || got[0] != "-" {
+		t.Errorf("got zones %v, want a single histogram without zone", got)
+	}
+	if histogram := gatherOne(t, s, "latency").GetHistogram(); histogram.GetSampleCount() != 2 {
+		t.Errorf("got %d observations, want 2", histogram.GetSampleCount())
+	}
+	families, _ := s.Gather()
+	for _, family := range families {
+		if family.GetName() == "up" {
+			t.Error("up exported beyond max_series taken by the native histogram")
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is prepended to flag names to form their environment variable
+// overrides, e.g. -port can be set with CUSTOM_EXPORTER_PORT.
+const EnvPrefix = "CUSTOM_EXPORTER_"
+
+// Options holds the command line options of the exporter.
+type Options struct {
+	ConfigFile string
+	Script     string
+	Port       string
+	// ListenOn are the addresses to listen on instead of Port.
+	ListenOn stringList
+	// SystemdSocket serves on the sockets passed by systemd instead.
+	SystemdSocket bool
+	Timeout       Duration
+	StatsD        string
+	Graphite      string
+
+	// StartupCheck is how scripts are checked before serving: "none",
+	// "stat" or "run".
+	StartupCheck string
+
+	// DebugPprof serves /debug/pprof/ and DebugScripts the last output of
+	// every script on /debug/scripts/ to the credentials in DebugAuthFile.
+	DebugPprof    bool
+	DebugScripts  bool
+	DebugAuthFile string
+
+	// TLSCert and TLSKey serve HTTPS instead of HTTP. The files are
+	// reloaded when they change.
+	TLSCert string
+	TLSKey  string
+
+	// TLSClientCA requires clients to present a certificate signed by one
+	// of its CAs, with a common name or SAN in TLSAllowedNames if set.
+	TLSClientCA     string
+	TLSAllowedNames string
+
+	// MetricsPath is the path metrics are served on.
+	MetricsPath string
+
+	// ReadyAfter selects when /readyz reports ready: "config", "any" or
+	// "all".
+	ReadyAfter string
+
+	// MaxRequests, ClientRate and ClientBurst limit the scrapes of
+	// MetricsPath; see ScrapeLimiter.
+	MaxRequests int
+	ClientRate  float64
+	ClientBurst int
+
+	// OpenMetrics serves the OpenMetrics format to scrapers asking for it;
+	// DisableCompression never gzips responses.
+	OpenMetrics        bool
+	DisableCompression bool
+
+	// AllowedNetworks are the only networks that may use the metrics and
+	// admin endpoints, if set.
+	AllowedNetworks stringList
+
+	// SecurityHeaders, Headers and CORSOrigins set the headers of HTTP
+	// responses; see WithHeaders.
+	SecurityHeaders bool
+	Headers         headerList
+	CORSOrigins     stringList
+
+	// AccessLog logs every HTTP request.
+	AccessLog bool
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the
+	// timeouts of the HTTP server; zero means no timeout.
+	ReadHeaderTimeout Duration
+	ReadTimeout       Duration
+	WriteTimeout      Duration
+	IdleTimeout       Duration
+
+	// HTTP2 serves HTTP/2 over TLS and H2C unencrypted HTTP/2 with prior
+	// knowledge. MaxConnections limits the open connections; zero means no
+	// limit. DisableKeepAlives closes connections after every request.
+	HTTP2             bool
+	H2C               bool
+	MaxConnections    int
+	DisableKeepAlives bool
+
+	// EnableLifecycle serves /-/quit to stop the exporter over HTTP.
+	EnableLifecycle bool
+
+	// EnableAdminAPI serves the admin API, /-/reload and /-/enable. It
+	// requires clients to authenticate.
+	EnableAdminAPI bool
+
+	// WebConfigFile is an exporter-toolkit web configuration file setting
+	// up TLS, basic auth and HTTP headers, like for official exporters.
+	WebConfigFile string
+
+	// MaxMemory is the soft memory limit of the exporter; runs whose parsed
+	// output alone exceeds it are rejected. Zero means no limit.
+	MaxMemory ByteSize
+
+	// Shard limits the scripts run to those of one of several replicas.
+	Shard Shard
+
+	source ConfigSource
+}
+
+// NewFlagSet returns a flag set bound to a new Options value.
+func NewFlagSet(name string) (*flag.FlagSet, *Options) {
+	opts := &Options{
+		Timeout:           Duration(DefaultInterval),
+		ReadHeaderTimeout: Duration(DefaultReadHeaderTimeout),
+		ReadTimeout:       Duration(DefaultReadTimeout),
+		IdleTimeout:       Duration(DefaultIdleTimeout),
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&opts.ConfigFile, "config", "", "Path to the configuration file, or a consul://, etcd:// or kubernetes:// URL")
+	fs.StringVar(&opts.Script, "script", "", "Path to a single script to execute (instead of -config)")
+	fs.StringVar(&opts.Port, "port", "8000", "Port to run the HTTP server on")
+	fs.Var(&opts.ListenOn, "web.listen-address", "Address to listen on instead of -port, as host:port, [ipv6]:port or unix:///path/to/socket; repeat or separate with commas for several")
+	fs.BoolVar(&opts.SystemdSocket, "web.systemd-socket", false, "Serve on the sockets passed by systemd socket activation instead of -port or -web.listen-address")
+	fs.Var(&opts.Timeout, "timeout", "Interval between runs of -script, in seconds or as a duration (e.g. 30s, 5m)")
+	fs.StringVar(&opts.StatsD, "statsd-address", "", "UDP address to receive StatsD metrics on (e.g. :9125), disabled if empty")
+	fs.StringVar(&opts.Graphite, "graphite-address", "", "TCP address to receive Graphite plaintext metrics on (e.g. :2003), disabled if empty")
+	fs.StringVar(&opts.StartupCheck, "startup-check", StartupCheckNone, "Refuse to start unless every script passes this check: none, stat (exists and is executable) or run (also runs once and parses its output)")
+	fs.BoolVar(&opts.DebugPprof, "debug-pprof", false, "Serve runtime profiles on /debug/pprof/, protected by -debug-auth-file")
+	fs.BoolVar(&opts.DebugScripts, "debug-scripts", false, "Keep the last raw output of every script and serve it with the parse result on /debug/scripts/<name>, protected by -debug-auth-file")
+	fs.StringVar(&opts.DebugAuthFile, "debug-auth-file", "", "File holding the user:password required by /debug/pprof/ and /debug/scripts/")
+	fs.StringVar(&opts.TLSCert, "web.tls-cert", "", "TLS certificate file to serve HTTPS with, reloaded when it changes")
+	fs.StringVar(&opts.TLSKey, "web.tls-key", "", "TLS private key file of -web.tls-cert")
+	fs.StringVar(&opts.TLSClientCA, "web.tls-client-ca", "", "CA certificates file; clients must present a certificate signed by one of them")
+	fs.StringVar(&opts.TLSAllowedNames, "web.tls-allowed-names", "", "Comma-separated common names or SANs allowed in client certificates, any if empty")
+	fs.StringVar(&opts.MetricsPath, "web.telemetry-path", "/metrics", "Path under which to expose metrics")
+	fs.StringVar(&opts.ReadyAfter, "web.ready-after", ReadyAfterAny, "When /readyz reports ready: after loading the config, after the first successful run of any script, or once all scripts succeeded (config, any or all)")
+	fs.IntVar(&opts.MaxRequests, "web.max-requests", 0, "Maximum number of concurrent scrapes, 0 for no limit")
+	fs.Float64Var(&opts.ClientRate, "web.client-rate-limit", 0, "Maximum scrapes per second of each client on average, 0 for no limit")
+	fs.IntVar(&opts.ClientBurst, "web.client-burst", 5, "Scrapes a client may make at once within -web.client-rate-limit")
+	fs.BoolVar(&opts.OpenMetrics, "web.enable-openmetrics", true, "Serve the OpenMetrics format to scrapers that accept it; false always serves the text format")
+	fs.BoolVar(&opts.DisableCompression, "web.disable-compression", false, "Never compress responses, even when the scraper accepts gzip")
+	fs.Var(&opts.AllowedNetworks, "web.allowed-networks", "Networks (CIDRs or IPs, separated by commas) allowed to access the metrics and admin endpoints, any if empty")
+	fs.BoolVar(&opts.SecurityHeaders, "web.security-headers", false, "Add standard security headers such as X-Content-Type-Options and Content-Security-Policy to responses")
+	fs.Var(&opts.Headers, "web.header", "Header to add to responses, as \"Name: value\"; repeat for several")
+	fs.Var(&opts.CORSOrigins, "web.cors-origins", "Origins allowed to make cross-origin requests, or * for any; separate with commas")
+	fs.BoolVar(&opts.AccessLog, "web.access-log", false, "Log every HTTP request with its peer, status, size and duration")
+	fs.Var(&opts.ReadHeaderTimeout, "web.read-header-timeout", "Maximum time to read the headers of a request, 0 for no limit")
+	fs.Var(&opts.ReadTimeout, "web.read-timeout", "Maximum time to read a whole request, 0 for no limit")
+	fs.Var(&opts.WriteTimeout, "web.write-timeout", "Maximum time to write a response, 0 for no limit; keep it above the longest run of on_scrape and probe scripts")
+	fs.Var(&opts.IdleTimeout, "web.idle-timeout", "Maximum time to keep an idle connection open, 0 for no limit")
+	fs.BoolVar(&opts.HTTP2, "web.http2", true, "Serve HTTP/2 to TLS clients that support it")
+	fs.BoolVar(&opts.H2C, "web.h2c", false, "Serve unencrypted HTTP/2 (h2c with prior knowledge) in addition to HTTP/1.1, e.g. behind a service mesh proxy")
+	fs.IntVar(&opts.MaxConnections, "web.max-connections", 0, "Maximum number of open connections; further connections wait to be accepted, 0 for no limit")
+	fs.BoolVar(&opts.DisableKeepAlives, "web.disable-keep-alives", false, "Close connections after every request instead of keeping them open for the next one")
+	fs.BoolVar(&opts.EnableLifecycle, "web.enable-lifecycle", false, "Serve /-/quit to shut down the exporter over HTTP")
+	fs.BoolVar(&opts.EnableAdminAPI, "web.enable-admin-api", false, "Serve the admin API on /api/v1/, /-/reload and /-/enable; requires basic auth, a bearer token or client certificates")
+	fs.StringVar(&opts.WebConfigFile, "web.config.file", "", "Path to an exporter-toolkit web configuration file enabling TLS or authentication")
+	fs.Var(&opts.MaxMemory, "max-memory", "Soft memory limit of the exporter (e.g. 512MiB); runs whose parsed output exceeds it are rejected, 0 for no limit")
+	fs.Var(&opts.Shard, "shard", "Only run the scripts of shard <index>/<count> (e.g. 1/3), to split them between replicas")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [options]\n\n", name)
+		fmt.Fprintf(fs.Output(), "Every option can also be set through the environment, e.g. %sPORT.\n\n", EnvPrefix)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nCommands:\n")
+		for _, cmd := range Commands {
+			fmt.Fprintf(fs.Output(), "  %-10s %s\n", cmd.Name, cmd.Summary)
+		}
+	}
+	return fs, opts
+}
+
+// ParseFlags parses args and then applies environment overrides for every
+// flag that was not given on the command line.
+func ParseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		name := EnvName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", value, name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// EnvName returns the environment variable overriding the named flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flagName))
+}
+
+// LoadConfig builds the exporter configuration from the options, either by
+// reading -config or by wrapping -script in a single-script configuration.
+func (o *Options) LoadConfig() (*Config, error) {
+	switch {
+	case o.ConfigFile != "" && o.Script != "":
+		return nil, errors.New("-config and -script are mutually exclusive")
+
+	case o.ConfigFile != "":
+		source, err := o.ConfigSource()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		data, err := source.Fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		return ParseConfig(o.ConfigFile, data)
+
+	case o.Script != "":
+		if o.Timeout <= 0 {
+			return nil, errors.New("-timeout must be greater than zero")
+		}
+		cfg := &Config{Scripts: []ScriptConfig{{
+			Name:     filepath.Base(o.Script),
+			Path:     o.Script,
+			Interval: o.Timeout,
+			Position: "-script",
+		}}}
+		return cfg, nil
+	}
+
+	return nil, errors.New("either -config or -script is required")
+}
+
+// ConfigSource returns the source of -config. The same source is returned
+// on every call so that watchers can track what was last fetched.
+func (o *Options) ConfigSource() (ConfigSource, error) {
+	if o.source == nil {
+		source, err := NewConfigSource(o.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		o.source = source
+	}
+	return o.source, nil
+}
+
+// ListenAddresses returns the addresses the HTTP server listens on, none
+// with SystemdSocket.
+func (o *Options) ListenAddresses() ([]string, error) {
+	if o.SystemdSocket {
+		if len(o.ListenOn) > 0 {
+			return nil, fmt.Errorf("-web.systemd-socket and -web.listen-address are mutually exclusive")
+		}
+		return nil, nil
+	}
+	if len(o.ListenOn) == 0 {
+		address, err := ParseListenAddress(net.JoinHostPort("", o.Port))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -port %q", o.Port)
+		}
+		return []string{address}, nil
+	}
+	addresses := make([]string, len(o.ListenOn))
+	for i, address := range o.ListenOn {
+		var err error
+		if addresses[i], err = ParseListenAddress(address); err != nil {
+			return nil, err
+		}
+	}
+	return addresses, nil
+}
+
+// stringList is a flag.Value collecting the values of a repeated flag, each
+// of which may hold several values separated by commas.
+type stringList []string
+
+// Set implements flag.Value.
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// String implements flag.Value.
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
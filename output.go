@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MaxCapturedOutput is how much of its stdout and of its stderr the last
+// run of a script keeps for /debug/scripts/.
+const MaxCapturedOutput = 64 << 10
+
+// ScriptOutput is the raw output of a run together with its parse result,
+// as served on /debug/scripts/<name>.
+type ScriptOutput struct {
+	Start  time.Time `json:"start"`
+	Stdout string    `json:"stdout"`
+	Stderr string    `json:"stderr"`
+	// Truncated tells whether stdout or stderr were longer than
+	// MaxCapturedOutput.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Metrics are the samples parsed from stdout, before relabeling and
+	// the other processing of the script's configuration.
+	Metrics    []ParsedMetric `json:"metrics"`
+	LineErrors []string       `json:"line_errors,omitempty"`
+	Error      string         `json:"error,omitempty"`
+
+	stdout, stderr capturedStream
+}
+
+// ParsedMetric is a sample parsed from the output of a script.
+type ParsedMetric struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     string            `json:"value"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+}
+
+// capturedStream keeps the first MaxCapturedOutput bytes written to it.
+type capturedStream struct {
+	data      []byte
+	truncated bool
+}
+
+func (c *capturedStream) Write(p []byte) (int, error) {
+	room := MaxCapturedOutput - len(c.data)
+	if len(p) > room {
+		c.truncated = true
+		c.data = append(c.data, p[:max(room, 0)]...)
+	} else {
+		c.data = append(c.data, p...)
+	}
+	return len(p), nil
+}
+
+// setParsed records the metrics parsed from the output and the malformed
+// lines reported in err.
+func (o *ScriptOutput) setParsed(metrics []Metric, err error) {
+	o.Metrics = make([]ParsedMetric, 0, len(metrics))
+	for _, m := range metrics {
+		parsed := ParsedMetric{
+			Name:   m.FullName(),
+			Labels: m.AllLabels(),
+			Value:  formatFloat(m.Value),
+		}
+		if !m.Timestamp.IsZero() {
+			parsed.Timestamp = &m.Timestamp
+		}
+		o.Metrics = append(o.Metrics, parsed)
+	}
+	var malformed ParseErrors
+	if errors.As(err, &malformed) {
+		for _, lineErr := range malformed {
+			o.LineErrors = append(o.LineErrors, lineErr.Error())
+		}
+	}
+}
+
+// finish records the captured streams and the result of the run.
+func (o *ScriptOutput) finish(err error) {
+	o.Stdout, o.Stderr = string(o.stdout.data), string(o.stderr.data)
+	o.Truncated = o.stdout.truncated || o.stderr.truncated
+	if err != nil {
+		o.Error = err.Error()
+	}
+}
+
+// outputKey is the context key of the ScriptOutput capturing a run.
+type outputKey struct{}
+
+// withOutput returns a context making executeCommand capture the output of
+// the script into output.
+func withOutput(ctx context.Context, output *ScriptOutput) context.Context {
+	return context.WithValue(ctx, outputKey{}, output)
+}
+
+// outputFrom returns the ScriptOutput of ctx, or nil.
+func outputFrom(ctx context.Context) *ScriptOutput {
+	output, _ := ctx.Value(outputKey{}).(*ScriptOutput)
+	return output
+}
+
+// HandleDebugScripts registers /debug/scripts/<name> on mux, serving the
+// output of the last run of a script, protected by auth.
+func (e *Exporter) HandleDebugScripts(mux *http.ServeMux, auth func(http.Handler) http.Handler) {
+	mux.Handle("GET /debug/scripts/{name}", auth(http.HandlerFunc(e.debugScriptHandler)))
+}
+
+func (e *Exporter) debugScriptHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	e.mu.RLock()
+	script, ok := e.scripts[name]
+	e.mu.RUnlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown script %q", name))
+		return
+	}
+
+	script.mu.RLock()
+	output := script.output
+	script.mu.RUnlock()
+	if output == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("script %q has not run yet", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, output)
+}
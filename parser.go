@@ -0,0 +1,828 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// Parser turns the output of a script into metrics. Malformed lines do not
+// stop parsing: they are returned as ParseErrors together with the metrics
+// of the valid lines.
+type Parser interface {
+	Parse(r io.Reader) ([]Metric, error)
+}
+
+// LineError reports a malformed line of script output.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors lists the malformed lines of a script's output.
+type ParseErrors []*LineError
+
+func (e ParseErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more malformed lines)", e[0], len(e)-1)
+}
+
+// scanLines calls parse for each line of r and collects the metrics it
+// returns. Errors are collected into ParseErrors.
+func scanLines(r io.Reader, parse func(line string) ([]Metric, error)) ([]Metric, error) {
+	var metrics []Metric
+	var malformed ParseErrors
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, math.MaxInt) // Line length is limited by ExecuteCommand.
+
+	for n := 1; scanner.Scan(); n++ {
+		parsed, err := parse(scanner.Text())
+		if err != nil {
+			malformed = append(malformed, &LineError{Line: n, Err: err})
+			continue
+		}
+		metrics = append(metrics, parsed...)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading command output: %w", err)
+	}
+	if len(malformed) > 0 {
+		return metrics, malformed
+	}
+	return metrics, nil
+}
+
+// Values of ScriptConfig.OnParseError.
+const (
+	ParseErrorFail = "fail"
+	ParseErrorSkip = "skip"
+)
+
+// FormatPrometheus selects passing the Prometheus text format through
+// instead of parsing the output into Metrics.
+const FormatPrometheus = "prometheus"
+
+// Values of ScriptConfig.OnInvalidValue.
+const (
+	InvalidValueFail    = "fail"
+	InvalidValueDrop    = "drop"
+	InvalidValueNaN     = "nan"
+	InvalidValueDefault = "default"
+)
+
+// NewParser returns the parser for the output format of a script.
+func NewParser(sc ScriptConfig) (Parser, error) {
+	states, err := stateIndexes(sc.StateSets)
+	if err != nil {
+		return nil, err
+	}
+	values := ValuePolicy{
+		Mode:    sc.OnInvalidValue,
+		Default: sc.DefaultValue,
+		States:  states,
+		Invalid: invalidValuesTotal.WithLabelValues(sc.Name),
+	}
+
+	switch sc.Format {
+	case "", "csv":
+		comma, err := ParseDelimiter(sc.Delimiter)
+		if err != nil {
+			return nil, err
+		}
+		return CSVParser{Header: sc.Header, Comma: comma, Values: values}, nil
+	case "json":
+		return JSONParser{Values: values}, nil
+	case "labels":
+		return LabelsParser{Values: values}, nil
+	case "regex":
+		parser, err := NewRegexParser(sc.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		parser.Values = values
+		return parser, nil
+	case FormatNagios:
+		return NagiosParser{Check: sc.Name, Values: values}, nil
+	case FormatInflux:
+		return InfluxParser{Values: values}, nil
+	case FormatCollectd:
+		return CollectdParser{Values: values}, nil
+	case FormatXPath:
+		parser, err := NewXPathParser(sc.XPath)
+		if err != nil {
+			return nil, err
+		}
+		parser.Values = values
+		return parser, nil
+	case FormatJSONPath:
+		parser, err := NewJSONPathParser(sc.JSONPath)
+		if err != nil {
+			return nil, err
+		}
+		parser.Values = values
+		return parser, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", sc.Format)
+}
+
+// CheckCmdOutput validates the output of the custom script.
+func CheckCmdOutput(fields []string) error {
+	if len(fields) != 7 && len(fields) != 8 {
+		return fmt.Errorf("got %d fields, want seven: component, process_name, application_name, env, domain_name, mon_type, metric_value and optionally a timestamp", len(fields))
+	}
+	return nil
+}
+
+// ValuePolicy parses metric values. Values that are empty or not numeric
+// are errors in the default "fail" mode; the other modes apply to NaN and
+// infinite values as well and drop the sample, export NaN or export Default
+// instead.
+type ValuePolicy struct {
+	Mode    string
+	Default float64
+
+	// States maps the names of the states of each state set to their index,
+	// by the metric of the state set.
+	States map[string]map[string]float64
+
+	// Invalid counts the values the policy was applied to.
+	Invalid prometheus.Counter
+}
+
+// Parse returns the value of s for the metric named key, see
+// Metric.stateKey, and whether the sample should be exported.
+func (p ValuePolicy) Parse(key, s string) (float64, bool, error) {
+	s = strings.TrimSpace(s)
+	if index, ok := p.States[key][s]; ok {
+		return index, true, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err == nil && !math.IsNaN(value) && !math.IsInf(value, 0) {
+		return value, true, nil
+	}
+	if p.Mode == "" || p.Mode == InvalidValueFail {
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid metric value %q", s)
+		}
+		return value, true, nil
+	}
+
+	if p.Invalid != nil {
+		p.Invalid.Inc()
+	}
+	switch p.Mode {
+	case InvalidValueDrop:
+		return 0, false, nil
+	case InvalidValueNaN:
+		return math.NaN(), true, nil
+	}
+	return p.Default, true, nil
+}
+
+// valuePair is one of several measurements of a value field.
+type valuePair struct {
+	key, value string
+}
+
+// splitValues splits a value field holding several measurements as space
+// separated key=value pairs, e.g. "cpu=0.4 mem=812 conns=113". It returns
+// nil for a single value.
+func splitValues(s string) ([]valuePair, error) {
+	if !strings.Contains(s, "=") {
+		return nil, nil
+	}
+	var pairs []valuePair
+	for _, field := range strings.Fields(s) {
+		key, value, _ := strings.Cut(field, "=")
+		if !labelNameRE.MatchString(key) {
+			return nil, fmt.Errorf("invalid measurement %q: want key=value", field)
+		}
+		pairs = append(pairs, valuePair{key, value})
+	}
+	return pairs, nil
+}
+
+// expandValues returns one copy of metric per measurement: the key becomes
+// the mon_type of unnamed metrics and a suffix of the name of named metrics.
+func expandValues(metric Metric, pairs []valuePair, policy ValuePolicy) ([]Metric, error) {
+	if metric.Name == "" && metric.MonType != "" {
+		return nil, fmt.Errorf("mon_type must be empty when the value holds several measurements")
+	}
+
+	metrics := make([]Metric, 0, len(pairs))
+	for _, pair := range pairs {
+		expanded := metric
+		if expanded.Name != "" {
+			expanded.Name += "_" + pair.key
+		} else {
+			expanded.MonType = pair.key
+		}
+
+		value, ok, err := policy.Parse(expanded.stateKey(), pair.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pair.key, err)
+		}
+		if ok {
+			expanded.Value = value
+			metrics = append(metrics, expanded)
+		}
+	}
+	return metrics, nil
+}
+
+// setValue sets the value of metric from a value field, which may hold
+// several measurements, and returns the metrics to export.
+func setValue(metric Metric, field string, policy ValuePolicy) ([]Metric, error) {
+	pairs, err := splitValues(field)
+	if err != nil {
+		return nil, err
+	}
+	if pairs != nil {
+		return expandValues(metric, pairs, policy)
+	}
+
+	value, ok, err := policy.Parse(metric.stateKey(), field)
+	if !ok {
+		return nil, err
+	}
+	metric.Value = value
+	return []Metric{metric}, nil
+}
+
+// ParseTimestamp parses a timestamp given as Unix seconds, possibly with a
+// fraction, or in RFC 3339 format.
+func ParseTimestamp(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: want Unix seconds or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// CSVParser parses RFC 4180 records of the form
+// component,process_name,application_name,env,domain_name,mon_type,metric_value
+// with an optional eighth timestamp field. Fields containing commas or
+// quotes can be quoted, e.g. "Queue, priority".
+//
+// The value may hold several measurements, "cpu=0.4 mem=812", which are
+// exported with their key as mon_type. The mon_type field must then be
+// empty or, without a timestamp, left out.
+//
+// With Header set, the first record names the columns instead, e.g.
+// "component,app,env,value", and records are mapped as described for
+// Columns.
+type CSVParser struct {
+	// Header reads the column names from the first record.
+	Header bool
+
+	// Comma is the field delimiter. Zero means a comma.
+	Comma rune
+
+	Values ValuePolicy
+}
+
+// ParseDelimiter returns the rune of a delimiter option. Besides a single
+// character it accepts the escape \t and the names "tab" and "pipe".
+func ParseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return ',', nil
+	case `\t`, "tab":
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q: must be a single character other than a quote or newline", s)
+	}
+	return r, nil
+}
+
+// Parse implements Parser.
+func (p CSVParser) Parse(r io.Reader) ([]Metric, error) {
+	var metrics []Metric
+	var malformed ParseErrors
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Checked by CheckCmdOutput.
+	reader.TrimLeadingSpace = true
+	if p.Comma != 0 {
+		reader.Comma = p.Comma
+	}
+
+	var columns *Columns
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if p.Header && columns == nil && err == nil {
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
+			if columns, err = NewColumns(fields); err != nil {
+				return nil, fmt.Errorf("invalid header: %w", err)
+			}
+			continue
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			malformed = append(malformed, &LineError{Line: parseErr.Line, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading command output: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		var parsed []Metric
+		if columns != nil {
+			parsed, err = columns.Metrics(fields, p.Values)
+		} else {
+			parsed, err = p.parseRecord(fields)
+		}
+		if err != nil {
+			malformed = append(malformed, &LineError{Line: line, Err: err})
+			continue
+		}
+		metrics = append(metrics, parsed...)
+	}
+
+	if len(malformed) > 0 {
+		return metrics, malformed
+	}
+	return metrics, nil
+}
+
+// parseRecord turns the fields of a CSV record into metrics.
+func (p CSVParser) parseRecord(fields []string) ([]Metric, error) {
+	if len(fields) == 6 && strings.Contains(fields[5], "=") {
+		// Several measurements without a mon_type field.
+		fields = append(fields[:5:5], "", fields[5])
+	}
+	if err := CheckCmdOutput(fields); err != nil {
+		return nil, err
+	}
+
+	var timestamp time.Time
+	if len(fields) == 8 {
+		var err error
+		if timestamp, err = ParseTimestamp(strings.TrimSpace(fields[7])); err != nil {
+			return nil, err
+		}
+		fields = fields[:7]
+	}
+
+	metric := Metric{
+		Component:       strings.TrimSpace(fields[0]),
+		ProcessName:     strings.TrimSpace(fields[1]),
+		ApplicationName: strings.TrimSpace(fields[2]),
+		Env:             strings.TrimSpace(fields[3]),
+		DomainName:      strings.TrimSpace(fields[4]),
+		MonType:         strings.TrimSpace(fields[5]),
+		Timestamp:       timestamp,
+	}
+	return setValue(metric, fields[6], p.Values)
+}
+
+// JSONParser parses one JSON object per line, e.g.
+//
+//	{"component": "web", "mon_type": "cpu", "value": 1.2, "labels": {"zone": "a"}}
+//
+// Fields use the same names as the labels of the CSV format. An optional
+// "name" exports the line as a metric of its own, with the fields above as
+// additional labels. Instead of a value, "values" may map mon_type to value
+// for several measurements. An optional "timestamp" holds Unix seconds or
+// an RFC 3339 string, an optional "trace_id" the trace exported as an
+// exemplar. Blank lines are ignored.
+type JSONParser struct {
+	Values ValuePolicy
+}
+
+// jsonLine is the structure of a line read by JSONParser.
+type jsonLine struct {
+	Name            string                     `json:"name"`
+	Component       string                     `json:"component"`
+	ProcessName     string                     `json:"process_name"`
+	ApplicationName string                     `json:"application_name"`
+	Env             string                     `json:"env"`
+	DomainName      string                     `json:"domain_name"`
+	MonType         string                     `json:"mon_type"`
+	Value           json.RawMessage            `json:"value"`
+	Values          map[string]json.RawMessage `json:"values"`
+	Labels          map[string]string          `json:"labels"`
+	Timestamp       json.RawMessage            `json:"timestamp"`
+	TraceID         string                     `json:"trace_id"`
+}
+
+// Parse implements Parser.
+func (p JSONParser) Parse(r io.Reader) ([]Metric, error) {
+	return scanLines(r, func(line string) ([]Metric, error) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil, nil
+		}
+
+		var parsed jsonLine
+		if err := json.Unmarshal([]byte(line), &parsed); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for name := range parsed.Labels {
+			if err := ValidateLabelName(name); err != nil {
+				return nil, err
+			}
+		}
+		var timestamp time.Time
+		if parsed.Timestamp != nil {
+			var err error
+			if timestamp, err = ParseTimestamp(strings.Trim(string(parsed.Timestamp), `"`)); err != nil {
+				return nil, err
+			}
+		}
+
+		metric := Metric{
+			Component:       parsed.Component,
+			ProcessName:     parsed.ProcessName,
+			ApplicationName: parsed.ApplicationName,
+			Env:             parsed.Env,
+			DomainName:      parsed.DomainName,
+			MonType:         parsed.MonType,
+			Labels:          parsed.Labels,
+			Timestamp:       timestamp,
+			TraceID:         parsed.TraceID,
+		}
+		if parsed.Name != "" {
+			var err error
+			if metric, err = nameMetric(metric, parsed.Name); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case parsed.Value != nil && parsed.Values != nil:
+			return nil, fmt.Errorf("value and values are mutually exclusive")
+		case parsed.Values != nil:
+			var pairs []valuePair
+			for key, raw := range parsed.Values {
+				if !labelNameRE.MatchString(key) {
+					return nil, fmt.Errorf("invalid measurement name %q", key)
+				}
+				pairs = append(pairs, valuePair{key, jsonValue(raw)})
+			}
+			sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+			return expandValues(metric, pairs, p.Values)
+		case parsed.Value == nil:
+			return nil, fmt.Errorf("missing value")
+		}
+
+		value, ok, err := p.Values.Parse(metric.stateKey(), jsonValue(parsed.Value))
+		if !ok {
+			return nil, err
+		}
+		metric.Value = value
+		return []Metric{metric}, nil
+	})
+}
+
+// nameMetric names metric and turns its non-empty fixed fields into
+// additional labels.
+func nameMetric(metric Metric, name string) (Metric, error) {
+	name, err := SanitizeMetricName(name)
+	if err != nil {
+		return Metric{}, err
+	}
+	named := Metric{Name: name, Timestamp: metric.Timestamp, TraceID: metric.TraceID, Labels: make(map[string]string)}
+	for label, value := range metric.AllLabels() {
+		if value != "" {
+			named.Labels[label] = value
+		}
+	}
+	return named, nil
+}
+
+// jsonValue returns a JSON number or string as text for ValuePolicy.
+func jsonValue(raw json.RawMessage) string {
+	if string(raw) == "null" {
+		return ""
+	}
+	return strings.Trim(string(raw), `"`)
+}
+
+// LabelsParser parses lines carrying their own metric name and labels, e.g.
+//
+//	queue_depth{queue="orders",priority=high} 42
+//
+// Label values may be quoted or bare. The label set is optional and the
+// value may be followed by a timestamp as in the CSV format. Blank lines and
+// lines starting with # are ignored.
+type LabelsParser struct {
+	Values ValuePolicy
+}
+
+// Parse implements Parser.
+func (p LabelsParser) Parse(r io.Reader) ([]Metric, error) {
+	return scanLines(r, func(line string) ([]Metric, error) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return nil, nil
+		}
+		metric, ok, err := p.parseLine(line)
+		if !ok {
+			return nil, err
+		}
+		return []Metric{metric}, nil
+	})
+}
+
+// parseLine parses a single line of the labels format and reports whether
+// the metric should be exported.
+func (p LabelsParser) parseLine(line string) (Metric, bool, error) {
+	var metric Metric
+	var rest string
+
+	if i := strings.IndexByte(line, '{'); i >= 0 {
+		metric.Name = strings.TrimSpace(line[:i])
+		end, labels, err := parseLabelSet(line[i+1:])
+		if err != nil {
+			return Metric{}, false, err
+		}
+		metric.Labels = labels
+		rest = line[i+1+end:]
+	} else {
+		metric.Name, rest, _ = strings.Cut(line, " ")
+	}
+
+	if !metricNameRE.MatchString(metric.Name) {
+		return Metric{}, false, fmt.Errorf("invalid metric name %q", metric.Name)
+	}
+	fields := strings.Fields(rest)
+	if len(fields) > 2 {
+		return Metric{}, false, fmt.Errorf("want a value and an optional timestamp after the metric")
+	}
+	fields = append(fields, "", "")
+
+	var ok bool
+	var err error
+	if metric.Value, ok, err = p.Values.Parse(metric.Name, fields[0]); !ok {
+		return Metric{}, false, err
+	}
+	if fields[1] != "" {
+		if metric.Timestamp, err = ParseTimestamp(fields[1]); err != nil {
+			return Metric{}, false, err
+		}
+	}
+	return metric, true, nil
+}
+
+// parseLabelSet parses comma separated name=value pairs up to the closing
+// brace and returns the offset just after it.
+func parseLabelSet(s string) (int, map[string]string, error) {
+	labels := make(map[string]string)
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i < len(s) && s[i] == '}' {
+			return i + 1, labels, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return 0, nil, fmt.Errorf("unterminated label set")
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		if err := CheckLabelName(name); err != nil {
+			return 0, nil, err
+		}
+		if _, ok := labels[name]; ok {
+			return 0, nil, fmt.Errorf("duplicate label %q", name)
+		}
+		i += eq + 1
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+
+		var value strings.Builder
+		if i < len(s) && s[i] == '"' {
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					if s[i] == 'n' {
+						value.WriteByte('\n')
+						continue
+					}
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return 0, nil, fmt.Errorf("unterminated value of label %q", name)
+			}
+			i++
+			labels[name] = value.String()
+		} else {
+			for ; i < len(s) && s[i] != ',' && s[i] != '}'; i++ {
+				value.WriteByte(s[i])
+			}
+			labels[name] = strings.TrimSpace(value.String())
+		}
+	}
+}
+
+// Columns maps named columns, the fields of a CSV header or the groups of
+// a regular expression, to metrics. Columns named like the CSV fields set
+// them, "name" sets the metric name, "value" the value, "timestamp" the
+// sample time and "trace_id" the exemplar trace; other columns become
+// additional labels. When the metric is
+// named, the CSV field names become additional labels as well. Unnamed
+// columns are ignored.
+type Columns struct {
+	names []string
+	named bool
+}
+
+// NewColumns checks the column names. A value column is required.
+func NewColumns(names []string) (*Columns, error) {
+	if !slices.Contains(names, "value") {
+		return nil, fmt.Errorf("no column named value")
+	}
+	named := slices.Contains(names, "name")
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		seen[name] = true
+
+		switch {
+		case name == "value" || name == "name" || name == "timestamp" || name == "trace_id":
+		case !named && slices.Contains(OutputLabels, name):
+		default:
+			if err := CheckLabelName(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Columns{names: names, named: named}, nil
+}
+
+// Metrics builds the metrics from the values of the columns. The value
+// column may hold several measurements as described for CSVParser.
+func (c *Columns) Metrics(values []string, policy ValuePolicy) ([]Metric, error) {
+	if len(values) != len(c.names) {
+		return nil, fmt.Errorf("got %d fields, want %d", len(values), len(c.names))
+	}
+
+	var metric Metric
+	var valueField string
+	for i, name := range c.names {
+		value := strings.TrimSpace(values[i])
+		switch {
+		case name == "":
+		case name == "value":
+			valueField = value
+		case name == "name":
+			metric.Name = value
+		case name == "trace_id":
+			metric.TraceID = value
+		case name == "timestamp":
+			t, err := ParseTimestamp(value)
+			if err != nil {
+				return nil, err
+			}
+			metric.Timestamp = t
+		case c.named || !metric.setField(name, value):
+			if metric.Labels == nil {
+				metric.Labels = make(map[string]string)
+			}
+			metric.Labels[name] = value
+		}
+	}
+	if metric.Name != "" {
+		var err error
+		if metric.Name, err = SanitizeMetricName(metric.Name); err != nil {
+			return nil, err
+		}
+	}
+	return setValue(metric, valueField, policy)
+}
+
+// RegexParser extracts metrics from free-form output with a regular
+// expression matched against each line, e.g.
+//
+//	(?P<component>\S+) queue depth: (?P<value>[0-9.]+)
+//
+// The named groups are mapped to the metric as described for Columns.
+// Lines that do not match are ignored.
+type RegexParser struct {
+	Values ValuePolicy
+
+	re      *regexp.Regexp
+	columns *Columns
+}
+
+// NewRegexParser compiles pattern and checks its group names.
+func NewRegexParser(pattern string) (*RegexParser, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("the regex format requires a pattern")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	columns, err := NewColumns(re.SubexpNames())
+	if err != nil {
+		return nil, fmt.Errorf("pattern: %w", err)
+	}
+	return &RegexParser{re: re, columns: columns}, nil
+}
+
+// Parse implements Parser.
+func (p *RegexParser) Parse(r io.Reader) ([]Metric, error) {
+	return scanLines(r, func(line string) ([]Metric, error) {
+		match := p.re.FindStringSubmatch(line)
+		if match == nil {
+			return nil, nil
+		}
+		return p.columns.Metrics(match, p.Values)
+	})
+}
+
+// ParsePassthrough validates script output in the Prometheus text format and
+// returns its metric families with labels added to every sample. Labels
+// already printed by the script are overridden.
+func ParsePassthrough(r io.Reader, labels map[string]string) ([]*dto.MetricFamily, error) {
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	parsed, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exposition format: %w", err)
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(parsed))
+	for _, family := range parsed {
+		for _, metric := range family.Metric {
+			metric.Label = mergeLabelPairs(metric.Label, labels)
+		}
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+	return families, nil
+}
+
+// mergeLabelPairs sets labels on pairs and returns them sorted by name.
+func mergeLabelPairs(pairs []*dto.LabelPair, labels map[string]string) []*dto.LabelPair {
+	for name, value := range labels {
+		found := false
+		for _, pair := range pairs {
+			if pair.GetName() == name {
+				pair.Value = proto.String(value)
+				found = true
+			}
+		}
+		if !found {
+			pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	return pairs
+}
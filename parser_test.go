@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// formatMetric renders a metric as name{label="value",...} value, leaving
+// out empty labels.
+func formatMetric(metric Metric) string {
+	var labels []string
+	for name, value := range metric.AllLabels() {
+		if value != "" {
+			labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+	sort.Strings(labels)
+	return fmt.Sprintf("%s{%s} %v", metric.FullName(), strings.Join(labels, ","), metric.Value)
+}
+
+func TestParsers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		sc      ScriptConfig
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "csv",
+			output: "web,nginx,shop,prod,example.com,cpu,1.5\nweb,nginx,shop,prod,example.com,,cpu=0.4 mem=812\n",
+			want: []string{
+				`prom_custom_custom_metrics{application_name="shop",component="web",domain_name="example.com",env="prod",mon_type="cpu",process_name="nginx"} 0.4`,
+				`prom_custom_custom_metrics{application_name="shop",component="web",domain_name="example.com",env="prod",mon_type="cpu",process_name="nginx"} 1.5`,
+				`prom_custom_custom_metrics{application_name="shop",component="web",domain_name="example.com",env="prod",mon_type="mem",process_name="nginx"} 812`,
+			},
+		},
+		{
+			name:    "csv malformed line",
+			output:  "web,nginx,shop,prod,example.com,cpu\nweb,nginx,shop,prod,example.com,cpu,2\n",
+			want:    []string{`prom_custom_custom_metrics{application_name="shop",component="web",domain_name="example.com",env="prod",mon_type="cpu",process_name="nginx"} 2`},
+			wantErr: true,
+		},
+		{
+			name:   "csv header",
+			sc:     ScriptConfig{Header: true, Delimiter: "pipe"},
+			output: "name|component|queue|value\nqueue_depth|web|orders|42\n",
+			want:   []string{`queue_depth{component="web",queue="orders"} 42`},
+		},
+		{
+			name:   "json",
+			sc:     ScriptConfig{Format: "json"},
+			output: `{"component": "web", "mon_type": "cpu", "value": 1.2, "labels": {"zone": "a"}}` + "\n\n" + `{"name": "requests", "values": {"get": 3, "post": 4}}` + "\n",
+			want: []string{
+				`prom_custom_custom_metrics{component="web",mon_type="cpu",zone="a"} 1.2`,
+				`requests_get{} 3`,
+				`requests_post{} 4`,
+			},
+		},
+		{
+			name:    "json malformed line",
+			sc:      ScriptConfig{Format: "json"},
+			output:  "{\"name\": \"up\", \"value\": 1}\n{\"name\": \n",
+			want:    []string{`up{} 1`},
+			wantErr: true,
+		},
+		{
+			name:   "labels",
+			sc:     ScriptConfig{Format: "labels"},
+			output: "# comment\nqueue_depth{queue=\"orders\",priority=high} 42\nup 1\n",
+			want: []string{
+				`queue_depth{priority="high",queue="orders"} 42`,
+				`up{} 1`,
+			},
+		},
+		{
+			name:    "labels invalid value",
+			sc:      ScriptConfig{Format: "labels"},
+			output:  "up one\nup 1\n",
+			want:    []string{`up{} 1`},
+			wantErr: true,
+		},
+		{
+			name:   "regex",
+			sc:     ScriptConfig{Format: "regex", Pattern: `(?P<component>\S+) queue depth: (?P<value>[0-9.]+)`},
+			output: "orders queue depth: 7\nnothing to see\n",
+			want:   []string{`prom_custom_custom_metrics{component="orders"} 7`},
+		},
+		{
+			name: "jsonpath",
+			sc: ScriptConfig{Format: FormatJSONPath, JSONPath: []JSONPathMetric{{
+				Name:   "queue_depth",
+				Path:   "$.queues[*]",
+				Value:  "@.depth",
+				Labels: map[string]string{"queue": "@.name", "region": "$.region"},
+			}}},
+			output: `{"region": "eu", "queues": [{"name": "orders", "depth": 3}, {"name": "mails", "depth": 5}]}`,
+			want: []string{
+				`queue_depth{queue="mails",region="eu"} 5`,
+				`queue_depth{queue="orders",region="eu"} 3`,
+			},
+		},
+		{
+			name: "xpath",
+			sc: ScriptConfig{Format: FormatXPath, XPath: []XPathMetric{{
+				Name:   "queue_depth",
+				Path:   "//queue",
+				Value:  "@depth",
+				Labels: map[string]string{"queue": "@name"},
+			}}},
+			output: `<queues><queue name="orders" depth="3"/><queue name="mails" depth="5"/></queues>`,
+			want: []string{
+				`queue_depth{queue="mails"} 5`,
+				`queue_depth{queue="orders"} 3`,
+			},
+		},
+		{
+			name:   "collectd",
+			sc:     ScriptConfig{Format: FormatCollectd},
+			output: "PUTVAL \"web1/cpu-0/percent-idle\" interval=10 N:97.5\nPUTNOTIF message=\"ignored\"\n",
+			want:   []string{`collectd_cpu_percent{cpu="0",instance="web1",type="idle"} 97.5`},
+		},
+		{
+			name:   "nagios",
+			sc:     ScriptConfig{Name: "load", Format: FormatNagios},
+			output: "OK - load average: 0.50 | load1=0.5;1;2;0;\n",
+			want: []string{
+				`nagios_perfdata_critical{check="load",label="load1"} 2`,
+				`nagios_perfdata_min{check="load",label="load1"} 0`,
+				`nagios_perfdata_warning{check="load",label="load1"} 1`,
+				`nagios_perfdata{check="load",label="load1"} 0.5`,
+			},
+		},
+		{
+			name:   "influx",
+			sc:     ScriptConfig{Format: FormatInflux},
+			output: "# comment\nweather,location=us-midwest temperature=82,humidity=71i,summary=\"warm\" 1465839830100400200\n",
+			want: []string{
+				`weather_humidity{location="us-midwest"} 71`,
+				`weather_temperature{location="us-midwest"} 82`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.sc.Name == "" {
+				tc.sc.Name = "test"
+			}
+			parser, err := NewParser(tc.sc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			metrics, err := parser.Parse(strings.NewReader(tc.output))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+			got := make([]string, len(metrics))
+			for i, metric := range metrics {
+				got[i] = formatMetric(metric)
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
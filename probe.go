@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ProbeEnv returns the name of the environment variable passing the probe
+// parameter name to the script.
+func ProbeEnv(name string) string {
+	return "PROBE_" + strings.ToUpper(name)
+}
+
+// ProbeHandler serves /probe?script=<name>&<param>=<value>, running the
+// named probe script with the given parameters, like the multi-target
+// pattern of the blackbox exporter. The response holds the metrics of the
+// run along with probe_success and probe_duration_seconds; a failed run is
+// reported through probe_success rather than an error status. The run is
+// limited to the script's Interval, or the scrape timeout of Prometheus if
+// shorter. opts configure the response like those of the metrics path.
+func (e *Exporter) ProbeHandler(opts promhttp.HandlerOpts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("script")
+		e.mu.RLock()
+		script, ok := e.scripts[name]
+		e.mu.RUnlock()
+		if !ok || !script.Config.Probe {
+			http.Error(w, fmt.Sprintf("unknown probe script %q", name), http.StatusNotFound)
+			return
+		}
+
+		sc := script.Config
+		sc.Env = maps.Clone(sc.Env)
+		if sc.Env == nil {
+			sc.Env = make(map[string]string)
+		}
+		for param, values := range query {
+			switch {
+			case param == "script":
+				continue
+			case !slices.Contains(sc.ProbeParams, param):
+				http.Error(w, fmt.Sprintf("parameter %q is not allowed for script %q", param, name), http.StatusBadRequest)
+				return
+			case len(values) > 1:
+				http.Error(w, fmt.Sprintf("parameter %q given more than once", param), http.StatusBadRequest)
+				return
+			}
+			sc.Env[ProbeEnv(param)] = values[0]
+		}
+
+		probe, err := NewScript(sc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		probe.limiter = script.limiter
+		probe.maxMemory = script.maxMemory
+
+		timeout := time.Duration(sc.Interval)
+		if header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); header != "" {
+			if seconds, err := strconv.ParseFloat(header, 64); err == nil && seconds > 0 {
+				timeout = min(timeout, time.Duration(seconds*float64(time.Second)))
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the probe script succeeded.",
+		})
+		probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "How long the probe script took to run, in seconds.",
+		})
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(probeSuccess, probeDuration)
+
+		start := time.Now()
+		err = probe.run(ctx)
+		probeDuration.Set(time.Since(start).Seconds())
+		if err != nil {
+			log.Printf("Error probing %s: %v", name, err)
+		} else {
+			probeSuccess.Set(1)
+		}
+
+		promhttp.HandlerFor(prometheus.Gatherers{registry, probe}, opts).ServeHTTP(w, r)
+	}
+}
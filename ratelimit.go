@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ScrapeLimiter protects a handler against too many requests: at most
+// maxInFlight at the same time, and per client at most rate requests per
+// second on average with bursts of burst requests. Rejected requests get a
+// 503 with a Retry-After header.
+type ScrapeLimiter struct {
+	maxInFlight int
+	rate        float64
+	burst       float64
+
+	mu       sync.Mutex
+	inFlight int
+	clients  map[string]*tokenBucket
+	pruned   time.Time
+}
+
+// tokenBucket holds the requests a client may still make.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewScrapeLimiter returns a limiter; zero maxInFlight or rate disables
+// the respective limit.
+func NewScrapeLimiter(maxInFlight int, rate float64, burst int) *ScrapeLimiter {
+	return &ScrapeLimiter{
+		maxInFlight: maxInFlight,
+		rate:        rate,
+		burst:       math.Max(float64(burst), 1),
+		clients:     make(map[string]*tokenBucket),
+	}
+}
+
+// Handler wraps handler with the limits.
+func (l *ScrapeLimiter) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if wait := l.acquire(client, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many scrapes, retry later.", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// acquire admits a request of client, or returns how long to wait before
+// retrying.
+func (l *ScrapeLimiter) acquire(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+		return time.Second
+	}
+	if l.rate > 0 {
+		l.prune(now)
+		bucket, ok := l.clients[client]
+		if !ok {
+			bucket = &tokenBucket{tokens: l.burst, updated: now}
+			l.clients[client] = bucket
+		}
+		bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+		bucket.updated = now
+		if bucket.tokens < 1 {
+			return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		}
+		bucket.tokens--
+	}
+	l.inFlight++
+	return 0
+}
+
+// release ends a request admitted by acquire.
+func (l *ScrapeLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+}
+
+// prune forgets the clients whose buckets have filled up again, at most
+// once a minute.
+func (l *ScrapeLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, bucket := range l.clients {
+		if now.Sub(bucket.updated) > full {
+			delete(l.clients, client)
+		}
+	}
+}
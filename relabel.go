@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Relabel actions.
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelLabelMap  = "labelmap"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+)
+
+// RelabelConfig is a relabeling rule applied to parsed metrics, following
+// the relabel_configs of Prometheus. The metric name is available as the
+// __name__ label.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	re *regexp.Regexp
+}
+
+// compile fills in the defaults of the rule and checks it.
+func (r *RelabelConfig) compile() error {
+	if r.Action == "" {
+		r.Action = RelabelReplace
+	}
+	if r.Regex == "" {
+		r.Regex = "(.*)"
+	}
+	re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("relabel config: invalid regex: %w", err)
+	}
+	r.re = re
+
+	switch r.Action {
+	case RelabelReplace:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel config: target_label is required for action %q", r.Action)
+		}
+	case RelabelKeep, RelabelDrop:
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("relabel config: source_labels are required for action %q", r.Action)
+		}
+	case RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("relabel config: unknown action %q", r.Action)
+	}
+	return nil
+}
+
+// apply relabels labels in place and reports whether the metric is kept.
+func (r *RelabelConfig) apply(labels map[string]string) bool {
+	separator, replacement := ";", "$1"
+	if r.Separator != nil {
+		separator = *r.Separator
+	}
+	if r.Replacement != nil {
+		replacement = *r.Replacement
+	}
+
+	values := make([]string, len(r.SourceLabels))
+	for i, name := range r.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, separator)
+
+	switch r.Action {
+	case RelabelKeep:
+		return r.re.MatchString(value)
+	case RelabelDrop:
+		return !r.re.MatchString(value)
+	case RelabelReplace:
+		match := r.re.FindStringSubmatchIndex(value)
+		if match == nil {
+			break
+		}
+		target := string(r.re.ExpandString(nil, r.TargetLabel, value, match))
+		if result := string(r.re.ExpandString(nil, replacement, value, match)); result != "" {
+			labels[target] = result
+		} else {
+			delete(labels, target)
+		}
+	case RelabelLabelMap:
+		for name, value := range maps.Clone(labels) {
+			if match := r.re.FindStringSubmatchIndex(name); match != nil {
+				labels[string(r.re.ExpandString(nil, replacement, name, match))] = value
+			}
+		}
+	case RelabelLabelDrop, RelabelLabelKeep:
+		for name := range labels {
+			if name != "__name__" && r.re.MatchString(name) == (r.Action == RelabelLabelDrop) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
+
+// Relabel applies rules to metrics and returns the metrics kept.
+func Relabel(metrics []Metric, rules []RelabelConfig) ([]Metric, error) {
+	if len(rules) == 0 {
+		return metrics, nil
+	}
+
+	kept := metrics[:0]
+	for _, metric := range metrics {
+		labels := metric.AllLabels()
+		labels["__name__"] = metric.FullName()
+		keep := true
+		for i := range rules {
+			if keep = rules[i].apply(labels); !keep {
+				break
+			}
+		}
+		if !keep {
+			continue
+		}
+
+		relabeled, err := relabeledMetric(metric, labels)
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, relabeled)
+	}
+	return kept, nil
+}
+
+// FilterLabels removes the labels not in keep, if set, and the labels in
+// drop from metrics. Metrics without a name losing a fixed field are named
+// explicitly, so that the field is no longer exported.
+func FilterLabels(metrics []Metric, keep, drop []string) []Metric {
+	if len(keep) == 0 && len(drop) == 0 {
+		return metrics
+	}
+
+	for i, metric := range metrics {
+		labels := metric.AllLabels()
+		removed := false
+		for name := range labels {
+			if name == "le" && metric.Type == MetricTypeHistogram || name == "quantile" && metric.Type == MetricTypeSummary {
+				continue
+			}
+			if len(keep) > 0 && !slices.Contains(keep, name) || slices.Contains(drop, name) {
+				delete(labels, name)
+				removed = true
+			}
+		}
+		if !removed {
+			continue
+		}
+		metrics[i] = Metric{
+			Name:      metric.FullName(),
+			Value:     metric.Value,
+			Labels:    labels,
+			Timestamp: metric.Timestamp,
+			TraceID:   metric.TraceID,
+			Type:      metric.Type,
+			part:      metric.part,
+		}
+	}
+	return metrics
+}
+
+// AddLabels sets the static labels on each metric that does not carry a
+// label of the same name.
+func AddLabels(metrics []Metric, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	for i := range metrics {
+		added := maps.Clone(metrics[i].Labels)
+		if added == nil {
+			added = make(map[string]string, len(labels))
+		}
+		for name, value := range labels {
+			if _, ok := added[name]; !ok {
+				added[name] = value
+			}
+		}
+		metrics[i].Labels = added
+	}
+}
+
+// relabeledMetric builds the metric described by labels, which hold the
+// metric name as __name__.
+func relabeledMetric(metric Metric, labels map[string]string) (Metric, error) {
+	relabeled := Metric{Value: metric.Value, Timestamp: metric.Timestamp, TraceID: metric.TraceID, Labels: make(map[string]string)}
+	if name := labels["__name__"]; name != DefaultMetricName {
+		if !metricNameRE.MatchString(name) {
+			return Metric{}, fmt.Errorf("relabeling gives invalid metric name %q", name)
+		}
+		relabeled.Name = name
+	}
+	delete(labels, "__name__")
+
+	for name, value := range labels {
+		if relabeled.Name == "" && relabeled.setField(name, value) {
+			continue
+		}
+		if err := CheckLabelName(name); err != nil {
+			return Metric{}, fmt.Errorf("relabeling gives %w", err)
+		}
+		relabeled.Labels[name] = value
+	}
+	return relabeled, nil
+}
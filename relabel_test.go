@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestRelabel(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		rules   []RelabelConfig
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "replace",
+			rules: []RelabelConfig{{SourceLabels: []string{"queue", "priority"}, TargetLabel: "route", Regex: "(.+);(.+)", Replacement: ptr("$1/$2")}},
+			want:  []string{`queue_depth{priority="high",queue="orders",route="orders/high"} 42`, `up{} 1`},
+		},
+		{
+			name:  "replace with empty value removes the label",
+			rules: []RelabelConfig{{SourceLabels: []string{"queue"}, TargetLabel: "priority", Replacement: ptr("")}},
+			want:  []string{`queue_depth{queue="orders"} 42`, `up{} 1`},
+		},
+		{
+			name:  "rename",
+			rules: []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "queue_(.*)", TargetLabel: "__name__", Replacement: ptr("orders_queue_$1")}},
+			want:  []string{`orders_queue_depth{priority="high",queue="orders"} 42`, `up{} 1`},
+		},
+		{
+			name:  "keep",
+			rules: []RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "queue_.*", Action: RelabelKeep}},
+			want:  []string{`queue_depth{priority="high",queue="orders"} 42`},
+		},
+		{
+			name:  "drop",
+			rules: []RelabelConfig{{SourceLabels: []string{"queue"}, Regex: "orders", Action: RelabelDrop}},
+			want:  []string{`up{} 1`},
+		},
+		{
+			name:  "labelmap",
+			rules: []RelabelConfig{{Regex: "(queue)", Replacement: ptr("source_$1"), Action: RelabelLabelMap}},
+			want:  []string{`queue_depth{priority="high",queue="orders",source_queue="orders"} 42`, `up{} 1`},
+		},
+		{
+			name:  "labeldrop",
+			rules: []RelabelConfig{{Regex: "prio.*", Action: RelabelLabelDrop}},
+			want:  []string{`queue_depth{queue="orders"} 42`, `up{} 1`},
+		},
+		{
+			name:  "labelkeep",
+			rules: []RelabelConfig{{Regex: "queue", Action: RelabelLabelKeep}},
+			want:  []string{`queue_depth{queue="orders"} 42`, `up{} 1`},
+		},
+		{
+			name:    "invalid metric name",
+			rules:   []RelabelConfig{{SourceLabels: []string{"__name__"}, TargetLabel: "__name__", Replacement: ptr("0up")}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := range tc.rules {
+				if err := tc.rules[i].compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			metrics := []Metric{
+				{Name: "queue_depth", Labels: map[string]string{"queue": "orders", "priority": "high"}, Value: 42},
+				{Name: "up", Value: 1},
+			}
+			metrics, err := Relabel(metrics, tc.rules)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			var got []string
+			for _, metric := range metrics {
+				got = append(got, formatMetric(metric))
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	for _, rule := range []RelabelConfig{
+		{Action: RelabelReplace},
+		{Action: RelabelKeep},
+		{Action: "hashmod"},
+		{Regex: "(", TargetLabel: "x"},
+	} {
+		if err := rule.compile(); err == nil {
+			t.Errorf("%+v: expected an error", rule)
+		}
+	}
+}
+
+// ptr returns a pointer to s, for the optional fields of RelabelConfig.
+func ptr(s string) *string {
+	return &s
+}
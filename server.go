@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default timeouts of the HTTP server, guarding against clients that open
+// connections and send requests slowly or not at all. There is no default
+// write timeout, as scrapes of on_scrape and probe scripts last as long as
+// the scripts run.
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = time.Minute
+	DefaultIdleTimeout       = 2 * time.Minute
+)
+
+// HTTPServer returns the HTTP server serving handler as set up by the
+// options, over TLS if tlsConfig is set.
+func (o *Options) HTTPServer(handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(o.HTTP2)
+	protocols.SetUnencryptedHTTP2(o.H2C)
+
+	server := &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		Protocols:         protocols,
+		ReadHeaderTimeout: time.Duration(o.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(o.ReadTimeout),
+		WriteTimeout:      time.Duration(o.WriteTimeout),
+		IdleTimeout:       time.Duration(o.IdleTimeout),
+	}
+	server.SetKeepAlivesEnabled(!o.DisableKeepAlives)
+	return server
+}
+
+// ConnLimiter limits the connections open at the same time across
+// listeners. Listeners at the limit wait for a connection or the listener
+// to be closed before accepting the next one.
+type ConnLimiter struct {
+	slots chan struct{}
+}
+
+// NewConnLimiter returns a limiter of max connections.
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{slots: make(chan struct{}, max)}
+}
+
+// Listener returns l limited by the limiter.
+func (c *ConnLimiter) Listener(l net.Listener) net.Listener {
+	return &limitedListener{Listener: l, slots: c.slots, closed: make(chan struct{})}
+}
+
+// limitedListener takes a slot for every connection it accepts. Closing it
+// ends the wait for a slot, so that Shutdown does not wait for idle
+// connections holding every slot.
+type limitedListener struct {
+	net.Listener
+	slots     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: sync.OnceFunc(func() { <-l.slots })}, nil
+}
+
+func (l *limitedListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// limitedConn frees its slot when it is closed.
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Shard selects the scripts run by one of several exporter replicas
+// sharing a configuration: every script is run by exactly one shard, picked
+// by a hash of its name. The zero Shard runs all scripts.
+type Shard struct {
+	Index int // 1-based
+	Count int
+}
+
+// Set implements flag.Value, parsing "<index>/<count>", e.g. "1/3".
+func (s *Shard) Set(value string) error {
+	if value == "" {
+		*s = Shard{}
+		return nil
+	}
+	index, count, ok := strings.Cut(value, "/")
+	i, err1 := strconv.Atoi(index)
+	n, err2 := strconv.Atoi(count)
+	if !ok || err1 != nil || err2 != nil || n < 1 || i < 1 || i > n {
+		return fmt.Errorf("invalid shard %q: use <index>/<count> such as 1/3", value)
+	}
+	*s = Shard{Index: i, Count: n}
+	return nil
+}
+
+// String implements flag.Value.
+func (s *Shard) String() string {
+	if s.Count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Contains reports whether the named script belongs to the shard.
+func (s Shard) Contains(name string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index-1
+}
+
+// Filter returns the scripts belonging to the shard. Dependencies on
+// scripts of other shards are dropped, as their runs cannot be waited for.
+func (s Shard) Filter(scripts []ScriptConfig) []ScriptConfig {
+	if s.Count <= 1 {
+		return scripts
+	}
+	var kept []ScriptConfig
+	for _, sc := range scripts {
+		if !s.Contains(sc.Name) {
+			continue
+		}
+		var deps []string
+		for _, dep := range sc.DependsOn {
+			if s.Contains(dep) {
+				deps = append(deps, dep)
+			}
+		}
+		sc.DependsOn = deps
+		kept = append(kept, sc)
+	}
+	return kept
+}
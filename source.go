@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSource provides the raw configuration document.
+type ConfigSource interface {
+	// Fetch returns the current configuration document.
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// ConfigWatcher is implemented by sources that can report changes.
+type ConfigWatcher interface {
+	// Watch blocks until the configuration changes or ctx is done.
+	Watch(ctx context.Context) error
+}
+
+// NewConfigSource returns the source for a -config value: a consul:// or
+// etcd:// URL, optionally with scheme, ca_file, cert_file and key_file
+// parameters, a kubernetes:///<namespace>[,<namespace>...]?script_dir=...
+// URL, optionally with selector and key parameters, or otherwise a local
+// file path.
+func NewConfigSource(location string) (ConfigSource, error) {
+	scheme, _, found := strings.Cut(location, "://")
+	if !found {
+		return &FileSource{path: location}, nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config URL: %w", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if scheme == "kubernetes" {
+		query := u.Query()
+		var namespaces []string
+		if key != "" {
+			namespaces = strings.Split(key, ",")
+		}
+		return NewKubernetesSource(namespaces, query.Get("selector"), query.Get("key"), query.Get("script_dir"))
+	}
+	if key == "" {
+		return nil, fmt.Errorf("config URL %s has no key", location)
+	}
+
+	switch scheme {
+	case "consul":
+		return NewConsulSource(u.Host, key, u.Query())
+	case "etcd":
+		return NewEtcdSource(u.Host, key, u.Query())
+	}
+	return nil, fmt.Errorf("unsupported config URL scheme %q", scheme)
+}
+
+// sourceTimeout bounds requests to a configuration store that do not wait
+// for changes.
+const sourceTimeout = 30 * time.Second
+
+// sourceTransport returns the URL scheme and HTTP transport for the query
+// parameters of a consul:// or etcd:// URL. scheme=https selects HTTPS,
+// verified against ca_file if set, with the client certificate in
+// cert_file and key_file.
+func sourceTransport(query url.Values) (string, *http.Transport, error) {
+	scheme := query.Get("scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	caFile, certFile, keyFile := query.Get("ca_file"), query.Get("cert_file"), query.Get("key_file")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch scheme {
+	case "http":
+		if caFile != "" || certFile != "" || keyFile != "" {
+			return "", nil, fmt.Errorf("ca_file, cert_file and key_file require scheme=https")
+		}
+		return scheme, transport, nil
+	case "https":
+	default:
+		return "", nil, fmt.Errorf("unsupported scheme %q", scheme)
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return "", nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return "", nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return "", nil, fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return "", nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = config
+	return scheme, transport, nil
+}
+
+// FileSource reads the configuration from a local file. It watches the
+// file and the directories matched by its include patterns with fsnotify.
+type FileSource struct {
+	path string
+
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	dirs    map[string]bool
+}
+
+// Fetch implements ConfigSource.
+func (f *FileSource) Fetch(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(f.path)
+}
+
+// Watch implements ConfigWatcher. It returns once the configuration file or
+// a file matching one of its include patterns is created, changed or
+// removed. Directories are watched rather than files so that editors
+// replacing files are noticed as well. When the directory part of a
+// pattern holds wildcards, every directory it matches is watched, and so
+// are their parents up to the first without wildcards, so that matching
+// directories being created or removed are noticed too.
+func (f *FileSource) Watch(ctx context.Context) error {
+	patterns, err := f.patterns()
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	if f.watcher == nil {
+		if f.watcher, err = fsnotify.NewWatcher(); err != nil {
+			f.mu.Unlock()
+			return err
+		}
+		f.dirs = make(map[string]bool)
+	}
+	watcher := f.watcher
+	var dirPatterns []string
+	for _, pattern := range patterns {
+		for _, parent := range parentPatterns(pattern) {
+			dirs := []string{parent}
+			if hasMeta(parent) {
+				dirPatterns = append(dirPatterns, parent)
+				dirs, _ = filepath.Glob(parent)
+			}
+			for _, dir := range dirs {
+				if f.dirs[dir] {
+					continue
+				}
+				if info, err := os.Stat(dir); hasMeta(parent) && (err != nil || !info.IsDir()) {
+					continue
+				}
+				if err := watcher.Add(dir); err != nil {
+					f.mu.Unlock()
+					return fmt.Errorf("failed to watch %s: %w", dir, err)
+				}
+				f.dirs[dir] = true
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-watcher.Errors:
+			return err
+		case event := <-watcher.Events:
+			name := filepath.Clean(event.Name)
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				// The watch of a removed directory is gone with it.
+				f.mu.Lock()
+				delete(f.dirs, name)
+				f.mu.Unlock()
+			}
+			if matchAny(patterns, name) || matchAny(dirPatterns, name) {
+				f.settle(ctx, watcher)
+				return nil
+			}
+		}
+	}
+}
+
+// patterns returns the absolute path of the configuration file followed by
+// its include patterns.
+func (f *FileSource) patterns() ([]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Include []string `yaml:"include"`
+	}
+	if data, err := configDocument(f.path, data); err == nil {
+		_ = yaml.Unmarshal(data, &cfg)
+	}
+
+	var patterns []string
+	for _, pattern := range append([]string{f.path}, cfg.Include...) {
+		abs, err := filepath.Abs(IncludePattern(f.path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, abs)
+	}
+	return patterns, nil
+}
+
+// settle drains events until the directory has been quiet for a moment, so
+// that a burst of writes results in a single reload.
+func (f *FileSource) settle(ctx context.Context, watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-watcher.Events:
+		case <-time.After(250 * time.Millisecond):
+			return
+		}
+	}
+}
+
+// parentPatterns returns the directory of pattern followed by its parents
+// up to the first one without wildcards.
+func parentPatterns(pattern string) []string {
+	dir := filepath.Dir(pattern)
+	parents := []string{dir}
+	for hasMeta(dir) {
+		dir = filepath.Dir(dir)
+		parents = append(parents, dir)
+	}
+	return parents
+}
+
+// hasMeta reports whether path contains any of the wildcards recognised by
+// filepath.Match.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// matchAny reports whether name matches one of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchConfig reloads the exporter whenever source reports a change, until
+// ctx is done. Sources that cannot be watched are ignored.
+func WatchConfig(ctx context.Context, source ConfigSource, exporter *Exporter) {
+	watcher, ok := source.(ConfigWatcher)
+	if !ok {
+		return
+	}
+
+	for ctx.Err() == nil {
+		if err := watcher.Watch(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error watching configuration: %v", err)
+				time.Sleep(5 * time.Second) // Retry after a delay on error
+			}
+			continue
+		}
+
+		log.Println("Configuration changed, reloading...")
+		if err := exporter.Reload(); err != nil {
+			log.Printf("Error reloading configuration: %v", err)
+		}
+	}
+}
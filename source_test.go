@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigSourceHTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/kv/exporter":
+			w.Header().Set("X-Consul-Index", "7")
+			_, _ = w.Write([]byte("scripts: []\n"))
+		case "/v3/kv/range":
+			_, _ = w.Write([]byte(`{"header":{"revision":"3"},"kvs":[{"value":"c2NyaXB0czogW10K"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	for _, scheme := range []string{"consul", "etcd"} {
+		location := scheme + "://" + host + "/exporter?scheme=https&ca_file=" + url.QueryEscape(caFile)
+		source, err := NewConfigSource(location)
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		data, err := source.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if string(data) != "scripts: []\n" {
+			t.Errorf("%s: got %q", scheme, data)
+		}
+
+		// Without the CA the server certificate is not trusted.
+		source, err = NewConfigSource(scheme + "://" + host + "/exporter?scheme=https")
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if _, err := source.Fetch(context.Background()); err == nil {
+			t.Errorf("%s: untrusted certificate accepted", scheme)
+		}
+	}
+}
+
+func TestConfigSourceTLSOptions(t *testing.T) {
+	for _, location := range []string{
+		"consul://localhost/exporter?ca_file=/etc/ca.pem",
+		"etcd://localhost/exporter?scheme=ftp",
+		"etcd://localhost/exporter?scheme=https&cert_file=/etc/cert.pem",
+	} {
+		if _, err := NewConfigSource(location); err == nil {
+			t.Errorf("%s: expected an error", location)
+		}
+	}
+}
+
+func TestFileSourceWatchGlobDirectories(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(config, []byte("include: [conf.d/*/scripts.yml]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "conf.d", "a"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	source := &FileSource{path: config}
+
+	// watch runs Watch and repeats change until it returns, leaving time for
+	// the events to settle in between.
+	watch := func(change func() error) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() { done <- source.Watch(ctx) }()
+		for {
+			if err := change(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+
+	watch(func() error {
+		return os.WriteFile(filepath.Join(dir, "conf.d", "a", "scripts.yml"), nil, 0o600)
+	})
+	watch(func() error {
+		err := os.Mkdir(filepath.Join(dir, "conf.d", "b"), 0o700)
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	})
+	watch(func() error {
+		return os.WriteFile(filepath.Join(dir, "conf.d", "b", "scripts.yml"), nil, 0o600)
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+// StateSet declares a metric reporting one of several states, e.g.
+//
+//	metric: service_state
+//	states: [running, degraded, down]
+//
+// The script reports the state by name or by its index in States. It is
+// exported as one series per state, labelled with the state, whose value is
+// 1 for the reported state and 0 otherwise, as an OpenMetrics state set.
+type StateSet struct {
+	// Metric is the metric name or, for lines without a name, the mon_type.
+	Metric string   `yaml:"metric"`
+	States []string `yaml:"states"`
+}
+
+// label returns the name of the label holding the state: the metric name,
+// as in OpenMetrics, or "state" for lines without a name.
+func (s StateSet) label(metric Metric) string {
+	if metric.Name == "" {
+		return "state"
+	}
+	return metric.Name
+}
+
+// stateKey returns the name metric is matched against StateSet.Metric by.
+func (m Metric) stateKey() string {
+	if m.Name == "" {
+		return m.MonType
+	}
+	return m.Name
+}
+
+// stateIndexes returns the index of every state name by the metric of its
+// state set.
+func stateIndexes(sets []StateSet) (map[string]map[string]float64, error) {
+	indexes := make(map[string]map[string]float64, len(sets))
+	for _, set := range sets {
+		if len(set.States) == 0 {
+			return nil, fmt.Errorf("state set %q: no states", set.Metric)
+		}
+		if _, ok := indexes[set.Metric]; ok {
+			return nil, fmt.Errorf("state set %q: declared twice", set.Metric)
+		}
+		indexes[set.Metric] = make(map[string]float64, len(set.States))
+		for i, state := range set.States {
+			indexes[set.Metric][state] = float64(i)
+		}
+	}
+	return indexes, nil
+}
+
+// ExpandStateSets replaces the metrics of state sets with a series per
+// state.
+func ExpandStateSets(metrics []Metric, sets []StateSet) ([]Metric, error) {
+	if len(sets) == 0 {
+		return metrics, nil
+	}
+
+	expanded := make([]Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		key := metric.stateKey()
+		i := slices.IndexFunc(sets, func(set StateSet) bool { return set.Metric == key })
+		if i < 0 {
+			expanded = append(expanded, metric)
+			continue
+		}
+
+		set := sets[i]
+		if metric.Value < 0 || int(metric.Value) >= len(set.States) || metric.Value != math.Trunc(metric.Value) {
+			return nil, fmt.Errorf("state set %s: invalid state %v", key, metric.Value)
+		}
+		for i, state := range set.States {
+			series := metric
+			series.Labels = maps.Clone(metric.Labels)
+			if series.Labels == nil {
+				series.Labels = make(map[string]string)
+			}
+			series.Labels[set.label(metric)] = state
+			series.Value = 0
+			if i == int(metric.Value) {
+				series.Value = 1
+			}
+			expanded = append(expanded, series)
+		}
+	}
+	return expanded, nil
+}
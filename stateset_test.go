@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStateSets(t *testing.T) {
+	sets := []StateSet{
+		{Metric: "service_state", States: []string{"running", "degraded", "ok"}},
+		{Metric: "disk_state", States: []string{"ok", "full"}},
+	}
+	parser, err := NewParser(ScriptConfig{Name: "test", Format: "labels", StateSets: sets})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		output string
+		want   map[string]float64 // The value of each state series.
+	}{
+		{"service_state ok", map[string]float64{"running": 0, "degraded": 0, "ok": 1}},
+		{"service_state 1", map[string]float64{"running": 0, "degraded": 1, "ok": 0}},
+		{"disk_state ok", map[string]float64{"ok": 1, "full": 0}},
+		{"disk_state full", map[string]float64{"ok": 0, "full": 1}},
+	} {
+		metrics, err := parser.Parse(strings.NewReader(tc.output))
+		if err != nil {
+			t.Errorf("%s: %v", tc.output, err)
+			continue
+		}
+		if metrics, err = ExpandStateSets(metrics, sets); err != nil {
+			t.Errorf("%s: %v", tc.output, err)
+			continue
+		}
+		got := make(map[string]float64)
+		for _, metric := range metrics {
+			got[metric.Labels[metric.Name]] = metric.Value
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.output, got, tc.want)
+		}
+		for state, value := range tc.want {
+			if got[state] != value {
+				t.Errorf("%s: got %v, want %v", tc.output, got, tc.want)
+				break
+			}
+		}
+	}
+
+	for _, output := range []string{"other_metric ok", "disk_state degraded", "service_state 3"} {
+		metrics, err := parser.Parse(strings.NewReader(output))
+		if err == nil {
+			_, err = ExpandStateSets(metrics, sets)
+		}
+		if err == nil {
+			t.Errorf("%s: got no error", output)
+		}
+	}
+}
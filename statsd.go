@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsD collects metrics pushed over UDP in the StatsD protocol, so that
+// short-lived jobs can report through the exporter. Counters (c), gauges
+// (g) and timers (ms, h) are supported; DogStatsD tags (|#key:value) become
+// labels. Timers are exported as summaries without quantiles, in seconds.
+type StatsD struct {
+	Registry *prometheus.Registry
+
+	mu     sync.Mutex
+	series map[string]*statsdSeries
+	kinds  map[string]string
+	errors prometheus.Counter
+}
+
+// statsdSeries is the current state of a single StatsD metric.
+type statsdSeries struct {
+	name   string
+	kind   string
+	labels map[string]string
+	value  float64
+	count  uint64
+}
+
+// NewStatsD returns a StatsD collector with a registry of its own.
+func NewStatsD() *StatsD {
+	s := &StatsD{
+		Registry: prometheus.NewRegistry(),
+		series:   make(map[string]*statsdSeries),
+		kinds:    make(map[string]string),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "custom_exporter_statsd_errors_total",
+			Help: "Number of malformed StatsD lines received.",
+		}),
+	}
+	s.Registry.MustRegister(s, s.errors)
+	return s
+}
+
+// ListenAndServe receives StatsD packets on the UDP address until the
+// connection fails.
+func (s *StatsD) ListenAndServe(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("statsd: %w", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("statsd: %w", err)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if err := s.Handle(line); err != nil {
+				s.errors.Inc()
+				log.Printf("Error handling StatsD line %q: %v", line, err)
+			}
+		}
+	}
+}
+
+// Handle applies a single line of the form name:value|type[|@rate][|#tags].
+func (s *StatsD) Handle(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return fmt.Errorf("missing value")
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return fmt.Errorf("missing type")
+	}
+
+	name = sanitizeName(name)
+	if !metricNameRE.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	raw, kind := parts[0], parts[1]
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", raw)
+	}
+
+	rate := 1.0
+	labels := make(map[string]string)
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			if rate, err = strconv.ParseFloat(part[1:], 64); err != nil || rate <= 0 || rate > 1 {
+				return fmt.Errorf("invalid sample rate %q", part)
+			}
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Split(part[1:], ",") {
+				key, value, _ := strings.Cut(tag, ":")
+				key = sanitizeName(key)
+				if err := CheckLabelName(key); err != nil {
+					return err
+				}
+				labels[key] = value
+			}
+		}
+	}
+
+	switch kind {
+	case "c", "g":
+	case "ms", "h":
+		name += "_seconds"
+		value /= 1000
+	default:
+		return fmt.Errorf("unsupported metric type %q", kind)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, ok := s.kinds[name]; ok && previous != kind && !(isTimer(previous) && isTimer(kind)) {
+		return fmt.Errorf("metric %s was received as %q before", name, previous)
+	}
+	s.kinds[name] = kind
+
+	key := seriesKey(name, labels)
+	series, ok := s.series[key]
+	if !ok {
+		series = &statsdSeries{name: name, kind: kind, labels: labels}
+		s.series[key] = series
+	}
+
+	switch {
+	case kind == "c":
+		series.value += value / rate
+	case kind == "g" && (raw[0] == '+' || raw[0] == '-'):
+		series.value += value
+	case kind == "g":
+		series.value = value
+	default:
+		series.value += value / rate
+		series.count += uint64(1 / rate)
+	}
+	return nil
+}
+
+func isTimer(kind string) bool {
+	return kind == "ms" || kind == "h"
+}
+
+// Describe implements prometheus.Collector. The collector is unchecked as
+// the metrics are only known once received.
+func (s *StatsD) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (s *StatsD) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, series := range s.series {
+		names, values := sortedLabels(series.labels)
+
+		desc := prometheus.NewDesc(series.name, "Metric received over StatsD.", names, nil)
+		var metric prometheus.Metric
+		var err error
+		switch series.kind {
+		case "c":
+			metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, series.value, values...)
+		case "g":
+			metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, series.value, values...)
+		default:
+			metric, err = prometheus.NewConstSummary(desc, series.count, series.value, nil, values...)
+		}
+		if err != nil {
+			metric = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- metric
+	}
+}
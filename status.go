@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statusTemplate renders the status page served at /status.
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Custom Exporter Status</title></head>
+<body>
+<h1>Custom Exporter Status</h1>
+<p>Version {{.Version}}, as of {{.Now}}. <a href="/">Home</a></p>
+<table border="1" cellpadding="4">
+<tr><th>Name</th><th>Schedule</th><th>State</th><th>Last run</th><th>Duration</th><th>Exit code</th><th>Series</th><th>Last error</th></tr>
+{{- range .Scripts}}
+<tr><td title="{{.Path}}">{{.Name}}</td><td>{{.Schedule}}</td><td>{{.State}}</td><td>{{.LastRun}}</td><td>{{.Duration}}</td><td>{{.ExitCode}}</td><td>{{.Series}}</td><td>{{.Error}}</td></tr>
+{{- else}}
+<tr><td colspan="8">No scripts configured.</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// statusRow is a script on the status page.
+type statusRow struct {
+	Name, Path, Schedule, State        string
+	LastRun, Duration, ExitCode, Error string
+	Series                             int
+}
+
+// newStatusRow formats status for the status page.
+func newStatusRow(status ScriptStatus, now time.Time) statusRow {
+	row := statusRow{
+		Name:     status.Name,
+		Path:     status.Path,
+		Schedule: status.Schedule,
+		Series:   status.Series,
+		LastRun:  "never",
+		Duration: "-",
+		ExitCode: "-",
+		Error:    status.Error,
+	}
+	switch {
+	case status.Disabled:
+		row.State = "disabled"
+	case status.Quarantined:
+		row.State = "quarantined"
+	case status.LastRun == nil:
+		row.State = "pending"
+	case status.Error != "":
+		row.State = "failing"
+	default:
+		row.State = "ok"
+	}
+	if status.LastRun != nil {
+		ago := now.Sub(*status.LastRun).Round(time.Second)
+		row.LastRun = status.LastRun.Format(time.RFC3339) + " (" + ago.String() + " ago)"
+		row.Duration = time.Duration(status.DurationSeconds * float64(time.Second)).Round(time.Millisecond).String()
+		row.ExitCode = strconv.Itoa(status.ExitCode)
+	}
+	return row
+}
+
+// StatusPage serves a page at /status showing the state and last run of
+// every script.
+func (e *Exporter) StatusPage(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	statuses := e.Statuses()
+	rows := make([]statusRow, len(statuses))
+	for i, status := range statuses {
+		rows[i] = newStatusRow(status, now)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := statusTemplate.Execute(w, struct {
+		Version, Now string
+		Scripts      []statusRow
+	}{Version, now.Format(time.RFC3339), rows})
+	if err != nil {
+		log.Printf("Error rendering status page: %v", err)
+	}
+}
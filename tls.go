@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// certReloader serves a certificate and key from files, loading them again
+// whenever either file changes so that rotated certificates are picked up
+// without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// newCertReloader loads the certificate and key, failing if they are
+// unusable.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. When the files
+// cannot be loaded again, the previous certificate is kept.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modified, err := r.lastModified()
+	if err == nil && r.cert != nil && modified.Equal(r.modified) {
+		return r.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile); err == nil {
+			if r.cert != nil {
+				log.Printf("Reloaded TLS certificate %s", r.certFile)
+			}
+			r.cert, r.modified = &cert, modified
+			return r.cert, nil
+		}
+	}
+	if r.cert == nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	log.Printf("Error reloading TLS certificate %s: %v", r.certFile, err)
+	return r.cert, nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// TLSConfig returns the server TLS configuration of the options, or nil to
+// serve plain HTTP.
+func (o *Options) TLSConfig() (*tls.Config, error) {
+	if o.WebConfigFile != "" && (o.TLSCert != "" || o.TLSKey != "" || o.TLSClientCA != "") {
+		return nil, fmt.Errorf("-web.config.file and the -web.tls-* options are mutually exclusive")
+	}
+	if o.TLSCert == "" && o.TLSKey == "" {
+		if o.TLSClientCA != "" || o.TLSAllowedNames != "" {
+			return nil, fmt.Errorf("-web.tls-client-ca and -web.tls-allowed-names require -web.tls-cert")
+		}
+		return nil, nil
+	}
+	if o.TLSCert == "" || o.TLSKey == "" {
+		return nil, fmt.Errorf("-web.tls-cert and -web.tls-key must be set together")
+	}
+	certs, err := newCertReloader(o.TLSCert, o.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+
+	if o.TLSClientCA == "" {
+		if o.TLSAllowedNames != "" {
+			return nil, fmt.Errorf("-web.tls-allowed-names requires -web.tls-client-ca")
+		}
+		return config, nil
+	}
+	pem, err := os.ReadFile(o.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", o.TLSClientCA)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if o.TLSAllowedNames != "" {
+		var allowed []string
+		for _, name := range strings.Split(o.TLSAllowedNames, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				allowed = append(allowed, name)
+			}
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return checkClientName(state.PeerCertificates[0], allowed)
+		}
+	}
+	return config, nil
+}
+
+// checkClientName checks that the common name or a SAN of a verified
+// client certificate is allowed.
+func checkClientName(cert *x509.Certificate, allowed []string) error {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, name := range names {
+		if name != "" && slices.Contains(allowed, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q is not allowed", cert.Subject.CommonName)
+}
+
+// ClientAuth reports whether the server only accepts clients presenting a
+// verified certificate or, with WebConfigFile, basic auth credentials.
+func (o *Options) ClientAuth() (bool, error) {
+	if o.TLSClientCA != "" {
+		return true, nil
+	}
+	if o.WebConfigFile == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(o.WebConfigFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read web configuration: %w", err)
+	}
+	var webConfig struct {
+		TLSServerConfig struct {
+			ClientAuthType string `yaml:"client_auth_type"`
+		} `yaml:"tls_server_config"`
+		BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+	}
+	if err := yaml.Unmarshal(data, &webConfig); err != nil {
+		return false, fmt.Errorf("failed to parse web configuration: %w", err)
+	}
+	return len(webConfig.BasicAuthUsers) > 0 || webConfig.TLSServerConfig.ClientAuthType == "RequireAndVerifyClientCert", nil
+}
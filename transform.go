@@ -0,0 +1,57 @@
+package main
+
+import "fmt"
+
+// ValueTransform converts the values of matching metrics, e.g. from
+// kilobytes to bytes with multiply: 1024 or from milliseconds to seconds
+// with divide: 1000. The value is multiplied, divided and offset in that
+// order.
+type ValueTransform struct {
+	// Metric is the metric name or, for lines without a name, the mon_type
+	// the transformation applies to. Empty matches every metric.
+	Metric string `yaml:"metric"`
+
+	Multiply *float64 `yaml:"multiply"`
+	Divide   *float64 `yaml:"divide"`
+	Offset   float64  `yaml:"offset"`
+}
+
+// check checks the transformation.
+func (t ValueTransform) check() error {
+	if t.Divide != nil && *t.Divide == 0 {
+		return fmt.Errorf("transform %q: divide must not be zero", t.Metric)
+	}
+	return nil
+}
+
+// matches reports whether the transformation applies to metric.
+func (t ValueTransform) matches(metric Metric) bool {
+	key := metric.Name
+	if key == "" {
+		key = metric.MonType
+	}
+	return t.Metric == "" || t.Metric == key
+}
+
+// apply returns the transformed value.
+func (t ValueTransform) apply(value float64) float64 {
+	if t.Multiply != nil {
+		value *= *t.Multiply
+	}
+	if t.Divide != nil {
+		value /= *t.Divide
+	}
+	return value + t.Offset
+}
+
+// TransformValues applies every matching transformation to the values of
+// metrics, in order.
+func TransformValues(metrics []Metric, transforms []ValueTransform) {
+	for i := range metrics {
+		for _, t := range transforms {
+			if t.matches(metrics[i]) {
+				metrics[i].Value = t.apply(metrics[i].Value)
+			}
+		}
+	}
+}
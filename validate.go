@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// Diagnostic is a problem found while validating the configuration.
+type Diagnostic struct {
+	Position string
+	Script   string
+	Warning  bool
+	Err      error
+}
+
+// String formats the diagnostic as "<file>:<line>: script "<name>": <error>".
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.Position != "" {
+		b.WriteString(d.Position + ": ")
+	}
+	if d.Warning {
+		b.WriteString("warning: ")
+	}
+	if d.Script != "" {
+		fmt.Fprintf(&b, "script %q: ", d.Script)
+	}
+	b.WriteString(d.Err.Error())
+	return b.String()
+}
+
+// ValidateConfig checks a loaded configuration against the system it is
+// going to run on and returns all problems found.
+func ValidateConfig(cfg *Config, opts *Options) []Diagnostic {
+	var diags []Diagnostic
+	if len(cfg.Scripts) == 0 {
+		diags = append(diags, Diagnostic{Position: opts.ConfigFile, Warning: true, Err: errors.New("no scripts defined")})
+	}
+	for _, sc := range cfg.Scripts {
+		if err := ValidateScript(sc); err != nil {
+			diags = append(diags, Diagnostic{Position: sc.Position, Script: sc.Name, Err: err})
+		}
+	}
+
+	diags = append(diags, duplicateMetrics(cfg)...)
+
+	if err := web.Validate(opts.WebConfigFile); err != nil {
+		diags = append(diags, Diagnostic{Position: opts.WebConfigFile, Err: err})
+	}
+	addresses, err := opts.ListenAddresses()
+	if err != nil {
+		diags = append(diags, Diagnostic{Position: "-web.listen-address", Err: err})
+	}
+	for _, address := range addresses {
+		if err := CheckListenAddress(address); err != nil {
+			diags = append(diags, Diagnostic{Position: "-web.listen-address", Warning: true, Err: err})
+		}
+	}
+	return diags
+}
+
+// ValidateScript checks a single script definition on disk. Shell commands
+// are not checked since their path is an arbitrary command line, and
+// scripts run through an interpreter need not be executable themselves. A
+// path without a separator is looked up in PATH, as it is when run.
+func ValidateScript(sc ScriptConfig) error {
+	if sc.WorkDir != "" {
+		if info, err := os.Stat(sc.WorkDir); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("workdir %s is not a directory", sc.WorkDir)
+		}
+	}
+
+	if interpreter := strings.Fields(sc.Interpreter); len(interpreter) > 0 {
+		if _, err := exec.LookPath(interpreter[0]); err != nil {
+			return fmt.Errorf("interpreter: %w", err)
+		}
+	}
+
+	if !sc.Shell {
+		path := sc.ScriptPath()
+		if sc.Interpreter == "" && !strings.ContainsRune(sc.Path, filepath.Separator) {
+			var err error
+			if path, err = exec.LookPath(sc.Path); err != nil {
+				return err
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		if sc.Interpreter == "" && info.Mode().Perm()&0o111 == 0 {
+			return fmt.Errorf("%s is not executable (chmod +x or set an interpreter)", path)
+		}
+	}
+
+	if _, err := NewScript(sc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Values of Options.StartupCheck.
+const (
+	StartupCheckNone = "none"
+	StartupCheckStat = "stat"
+	StartupCheckRun  = "run"
+)
+
+// StartupCheck checks every script of cfg as selected by mode before the
+// exporter starts serving. With StartupCheckRun each script is also run
+// once, limited to its interval, and must succeed; collectd scripts, which
+// usually never exit, and probe scripts, which need the parameters of a
+// request, are only checked on disk.
+func StartupCheck(cfg *Config, mode string) error {
+	switch mode {
+	case "", StartupCheckNone:
+		return nil
+	case StartupCheckStat, StartupCheckRun:
+	default:
+		return fmt.Errorf("-startup-check must be %q, %q or %q", StartupCheckNone, StartupCheckStat, StartupCheckRun)
+	}
+	log.Printf("Checking scripts (%s)...", mode)
+
+	var errs []error
+	for _, sc := range cfg.Scripts {
+		if err := ValidateScript(sc); err != nil {
+			errs = append(errs, fmt.Errorf("script %q: %w", sc.Name, err))
+			continue
+		}
+		if mode != StartupCheckRun || sc.Format == FormatCollectd || sc.Probe {
+			continue
+		}
+
+		script, err := NewScript(sc)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sc.Interval))
+			err = script.Run(ctx)
+			cancel()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("script %q: %w", sc.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// duplicateMetrics reports scripts whose metrics cannot be told apart: when
+// two scripts share the same static labels, any line they both print ends up
+// as a duplicate series and fails the scrape.
+func duplicateMetrics(cfg *Config) []Diagnostic {
+	var diags []Diagnostic
+	first := make(map[string]ScriptConfig)
+	for _, sc := range cfg.Scripts {
+		key := labelsKey(sc.Labels)
+		other, ok := first[key]
+		if !ok {
+			first[key] = sc
+			continue
+		}
+
+		diag := Diagnostic{Position: sc.Position, Script: sc.Name}
+		if sc.Path == other.Path && strings.Join(sc.Args, "\x00") == strings.Join(other.Args, "\x00") {
+			diag.Err = fmt.Errorf("duplicates the definition of script %q (%s)", other.Name, other.Position)
+		} else {
+			diag.Warning = true
+			diag.Err = fmt.Errorf("has the same labels as script %q (%s); add a distinguishing label to avoid duplicate series", other.Name, other.Position)
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+// labelsKey returns a canonical string for a label set.
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// CheckListenAddress reports whether addr is already taken by another
+// process.
+func CheckListenAddress(addr string) error {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		// Listening would replace the socket of a running exporter.
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("cannot listen on %s: socket in use", addr)
+		}
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+	return listener.Close()
+}
@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestValidateScriptLooksUpPath(t *testing.T) {
+	if err := ValidateScript(ScriptConfig{Name: "sh", Path: "sh"}); err != nil {
+		t.Errorf("command in PATH: %v", err)
+	}
+	if err := ValidateScript(ScriptConfig{Name: "missing", Path: "custom-exporter-missing"}); err == nil {
+		t.Error("missing command accepted")
+	}
+}
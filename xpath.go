@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+// FormatXPath selects extracting metrics from a single XML document.
+const FormatXPath = "xpath"
+
+// XPathMetric extracts a metric from an XML document. Path selects the
+// nodes to export, one sample each. Value and Labels are XPath expressions
+// evaluated relative to every selected node, e.g. "@depth", "name",
+// "count(item)" or "/status/@version". Without Value the text of the node
+// is the value. The optional Timestamp gives when the value was observed,
+// in Unix seconds or RFC 3339.
+type XPathMetric struct {
+	Name      string            `yaml:"name"`
+	Path      string            `yaml:"path"`
+	Value     string            `yaml:"value"`
+	Timestamp string            `yaml:"timestamp"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+// compiledXPathMetric is an XPathMetric with compiled expressions.
+type compiledXPathMetric struct {
+	name      string
+	path      *xpath.Expr
+	value     *xpath.Expr
+	timestamp *xpath.Expr
+	labels    map[string]*xpath.Expr
+}
+
+// XPathParser extracts metrics from a single XML document printed by the
+// script, as configured by XPathMetrics.
+type XPathParser struct {
+	Values ValuePolicy
+
+	metrics []compiledXPathMetric
+}
+
+// NewXPathParser compiles the expressions of metrics.
+func NewXPathParser(metrics []XPathMetric) (*XPathParser, error) {
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("the xpath format requires xpath metrics")
+	}
+
+	p := &XPathParser{}
+	for _, m := range metrics {
+		if !metricNameRE.MatchString(m.Name) {
+			return nil, fmt.Errorf("xpath: invalid metric name %q", m.Name)
+		}
+		compiled := compiledXPathMetric{name: m.Name, labels: make(map[string]*xpath.Expr)}
+		var err error
+		if compiled.path, err = xpath.Compile(m.Path); err != nil {
+			return nil, fmt.Errorf("xpath %s: invalid path: %w", m.Name, err)
+		}
+		if m.Value != "" {
+			if compiled.value, err = xpath.Compile(m.Value); err != nil {
+				return nil, fmt.Errorf("xpath %s: invalid value: %w", m.Name, err)
+			}
+		}
+		if m.Timestamp != "" {
+			if compiled.timestamp, err = xpath.Compile(m.Timestamp); err != nil {
+				return nil, fmt.Errorf("xpath %s: invalid timestamp: %w", m.Name, err)
+			}
+		}
+		for name, expr := range m.Labels {
+			if err := CheckLabelName(name); err != nil {
+				return nil, fmt.Errorf("xpath %s: %w", m.Name, err)
+			}
+			if compiled.labels[name], err = xpath.Compile(expr); err != nil {
+				return nil, fmt.Errorf("xpath %s: invalid label %s: %w", m.Name, name, err)
+			}
+		}
+		p.metrics = append(p.metrics, compiled)
+	}
+	return p, nil
+}
+
+// Parse implements Parser. Values that cannot be used are reported as
+// malformed, numbered by their metric's position in the configuration.
+func (p *XPathParser) Parse(r io.Reader) ([]Metric, error) {
+	doc, err := xmlquery.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XML document: %w", err)
+	}
+
+	var metrics []Metric
+	var malformed ParseErrors
+	for i, m := range p.metrics {
+		nodes := m.path.Select(xmlquery.CreateXPathNavigator(doc))
+		for nodes.MoveNext() {
+			node := nodes.Current().Copy()
+			metric := Metric{Name: m.name, Labels: make(map[string]string, len(m.labels))}
+			for name, expr := range m.labels {
+				metric.Labels[name] = evaluateXPath(expr, node.Copy())
+			}
+
+			text := node.Value()
+			if m.value != nil {
+				text = evaluateXPath(m.value, node.Copy())
+			}
+			if m.timestamp != nil {
+				if metric.Timestamp, err = ParseTimestamp(evaluateXPath(m.timestamp, node.Copy())); err != nil {
+					malformed = append(malformed, &LineError{Line: i + 1, Err: fmt.Errorf("%s: %w", m.name, err)})
+					continue
+				}
+			}
+			value, ok, err := p.Values.Parse(metric.stateKey(), text)
+			if err != nil {
+				malformed = append(malformed, &LineError{Line: i + 1, Err: fmt.Errorf("%s: %w", m.name, err)})
+				continue
+			}
+			if ok {
+				metric.Value = value
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+
+	if len(malformed) > 0 {
+		return metrics, malformed
+	}
+	return metrics, nil
+}
+
+// evaluateXPath evaluates expr relative to node and returns the result as
+// text: the text of the first node for node sets, 1 or 0 for booleans.
+func evaluateXPath(expr *xpath.Expr, node xpath.NodeNavigator) string {
+	switch result := expr.Evaluate(node).(type) {
+	case float64:
+		return strconv.FormatFloat(result, 'g', -1, 64)
+	case string:
+		return result
+	case bool:
+		if result {
+			return "1"
+		}
+		return "0"
+	case *xpath.NodeIterator:
+		if result.MoveNext() {
+			return result.Current().Value()
+		}
+	}
+	return ""
+}